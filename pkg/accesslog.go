@@ -0,0 +1,156 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// countingResponseWriter records the status and number of body bytes written through it.
+// Unwrap lets http.ResponseController (used by ReverseProxy to flush, hijack for upgrades etc)
+// get at the real writer.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *countingResponseWriter) WriteHeader(status int) {
+	// 1xx are informational, the real status is still to come.
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countingResponseWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// countingBody counts the request body bytes read through it.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// AccessLogEntry is what's logged for a request, and what a routers LogFormat template is executed with.
+type AccessLogEntry struct {
+	Method   string
+	URI      string
+	Path     string
+	Remote   string
+	Status   int
+	BytesIn  int64
+	BytesOut int64
+	Duration time.Duration
+
+	// Router and Backend are empty if the request didn't get that far.
+	Router  string
+	Backend string
+}
+
+type accessLogKey struct{}
+
+// accessLogState is filled in as the request is routed and proxied, for the access log.
+type accessLogState struct {
+	router  *BackendRouter
+	backend string
+}
+
+func accessLogFromContext(req *http.Request) *accessLogState {
+	state, _ := req.Context().Value(accessLogKey{}).(*accessLogState)
+	return state
+}
+
+// compileLogFormat parses the routers LogFormat, nil if it doesn't have one.
+func (ber *BackendRouter) compileLogFormat() (*template.Template, error) {
+	if ber.LogFormat == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New(ber.String()).Parse(ber.LogFormat)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid log format for router %s : %s", ber.String(), err.Error())
+	}
+	return tmpl, nil
+}
+
+// accessLogged wraps the response writer and request body with counters and returns them along with
+// the func that writes the access log entry once the request is done.
+func (l *LBLight) accessLogged(res http.ResponseWriter, req *http.Request) (http.ResponseWriter, *http.Request, func()) {
+	start := time.Now()
+	counted := &countingResponseWriter{ResponseWriter: res}
+	var body *countingBody
+	if req.Body != nil && req.Body != http.NoBody {
+		body = &countingBody{ReadCloser: req.Body}
+		req.Body = body
+	}
+
+	state := &accessLogState{}
+	req = req.WithContext(context.WithValue(req.Context(), accessLogKey{}, state))
+
+	entry := AccessLogEntry{Method: req.Method, URI: req.RequestURI, Path: req.URL.Path, Remote: req.RemoteAddr}
+	return counted, req, func() {
+		if body != nil {
+			entry.BytesIn = body.n
+		}
+		entry.Status = counted.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.BytesOut = counted.n
+		entry.Duration = time.Since(start)
+		entry.Backend = state.backend
+
+		if state.router != nil {
+			entry.Router = state.router.String()
+			if state.router.logTemplate != nil {
+				l.logAccessTemplate(state.router.logTemplate, entry)
+				return
+			}
+		}
+
+		l.AccessLog.WithFields(log.Fields{
+			"method":      entry.Method,
+			"uri":         entry.URI,
+			"remote":      entry.Remote,
+			"status":      entry.Status,
+			"bytes_in":    entry.BytesIn,
+			"bytes_out":   entry.BytesOut,
+			"duration_ms": entry.Duration.Milliseconds(),
+		}).Info("access")
+	}
+}
+
+// logAccessTemplate logs the entry formatted by a routers LogFormat template.
+func (l *LBLight) logAccessTemplate(tmpl *template.Template, entry AccessLogEntry) {
+	var line strings.Builder
+	if err := tmpl.Execute(&line, entry); err != nil {
+		log.Errorf("Unable to format access log for URL %s : %s", entry.URI, err.Error())
+		return
+	}
+	l.AccessLog.Info(line.String())
+}
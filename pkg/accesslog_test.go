@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// lockedBuffer is a bytes.Buffer safe to read while the logger writes to it.
+type lockedBuffer struct {
+	mux sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(p []byte) (int, error) {
+	lb.mux.Lock()
+	defer lb.mux.Unlock()
+	return lb.buf.Write(p)
+}
+
+func (lb *lockedBuffer) String() string {
+	lb.mux.Lock()
+	defer lb.mux.Unlock()
+	return lb.buf.String()
+}
+
+// accessLogTo returns a logger writing JSON lines to buf.
+func accessLogTo(buf *lockedBuffer) *log.Logger {
+	logger := log.New()
+	logger.Out = buf
+	logger.Formatter = &log.JSONFormatter{}
+	return logger
+}
+
+func TestAccessLogBytes(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.Write([]byte("hello world"))
+	})
+
+	l := NewLBLight(0)
+	buf := &lockedBuffer{}
+	l.AccessLog = accessLogTo(buf)
+	addRouter(t, l, routerFor(t, backend, "/"))
+	lb := serveLB(t, l)
+
+	req, _ := http.NewRequest(http.MethodPost, lb.URL+"/upload", strings.NewReader(strings.Repeat("x", 1234)))
+	if resp, body := doRequest(t, req); resp.StatusCode != http.StatusOK || body != "hello world" {
+		t.Fatalf("expected 200 hello world, got %d %q", resp.StatusCode, body)
+	}
+
+	// logged once the handler returns, which can be just after the client has the response.
+	waitFor(t, "the access log line", func() bool {
+		return strings.Contains(buf.String(), "\n")
+	})
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(buf.String()), &entry); err != nil {
+		t.Fatalf("unable to decode access log %q : %s", buf.String(), err)
+	}
+	if entry["bytes_in"] != float64(1234) || entry["bytes_out"] != float64(11) || entry["status"] != float64(200) {
+		t.Errorf("expected bytes_in 1234, bytes_out 11 and status 200, got %v", entry)
+	}
+}
+
+func TestLogFormat(t *testing.T) {
+	backend := newBackendServer(t, textHandler("ok"))
+
+	l := NewLBLight(0)
+	buf := &lockedBuffer{}
+	l.AccessLog = accessLogTo(buf)
+	ber := routerFor(t, backend, "/")
+	ber.LogFormat = "{{.Method}} {{.Path}} {{.Status}} {{.BytesOut}} {{.Backend}}"
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	get(t, lb.URL+"/page")
+	waitFor(t, "the access log line", func() bool {
+		return strings.Contains(buf.String(), "\n")
+	})
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(buf.String()), &entry); err != nil {
+		t.Fatalf("unable to decode access log %q : %s", buf.String(), err)
+	}
+	// backends log as host:port/id.
+	expected := "GET /page 200 2 " + strings.TrimPrefix(backend.URL, "http://") + "/"
+	if line, _ := entry["msg"].(string); !strings.HasPrefix(line, expected) {
+		t.Errorf("expected the line to start %q, got %q", expected, line)
+	}
+
+	bad := routerFor(t, backend, "/bad")
+	bad.LogFormat = "{{.Status"
+	if err := l.AddBackendRouter(bad); err == nil {
+		t.Errorf("expected an invalid LogFormat to fail registration")
+	}
+}
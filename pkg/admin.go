@@ -0,0 +1,166 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// AdminHandler returns the handler for the admin API. Intended to be served on a separate
+// (internal) port from the traffic:
+//
+//	/stats    JSON dump of Stats()
+//	/metrics  Prometheus text format
+//	/readyz   200 once every router has enough healthy backends (see Ready), 503 otherwise or while draining
+//	/debug/inflight  JSON list of the requests currently with a backend (see Inflight)
+//	/debug/inflight/<id>/cancel  POST to cancel an in flight request (see CancelInflight)
+func (l *LBLight) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", l.handleStats)
+	mux.HandleFunc("/metrics", l.handleMetrics)
+	mux.HandleFunc("/readyz", l.handleReady)
+	mux.HandleFunc("/debug/inflight", l.handleInflight)
+	mux.HandleFunc("/debug/inflight/", l.handleCancelInflight)
+	return mux
+}
+
+func (l *LBLight) handleCancelInflight(res http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/debug/inflight/"), "/")
+	if len(parts) != 2 || parts[1] != "cancel" {
+		http.NotFound(res, req)
+		return
+	}
+	if req.Method != http.MethodPost {
+		res.Header().Set("Allow", http.MethodPost)
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		http.Error(res, "invalid request id", http.StatusBadRequest)
+		return
+	}
+	if !l.CancelInflight(id) {
+		http.Error(res, "no such in flight request", http.StatusNotFound)
+		return
+	}
+	log.Warnf("Cancelled in flight request %d", id)
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func (l *LBLight) handleInflight(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(l.Inflight()); err != nil {
+		log.Errorf("Unable to write in flight requests %s", err.Error())
+	}
+}
+
+func (l *LBLight) handleReady(res http.ResponseWriter, req *http.Request) {
+	if !l.Ready() {
+		res.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(res, "not ready\n")
+		return
+	}
+	io.WriteString(res, "ready\n")
+}
+
+func (l *LBLight) handleStats(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(l.Stats()); err != nil {
+		log.Errorf("Unable to write stats %s", err.Error())
+	}
+}
+
+// handleMetrics writes the stats in the Prometheus text exposition format. Done by hand
+// instead of pulling in the Prometheus client, there isn't much to it.
+func (l *LBLight) handleMetrics(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(res, l.Stats())
+}
+
+func writeMetrics(w io.Writer, stats Stats) {
+	writeConnectionMetrics(w, stats.Connections)
+
+	fmt.Fprintln(w, "# HELP lblight_buffered_request_bytes Bytes of request bodies currently buffered.")
+	fmt.Fprintln(w, "# TYPE lblight_buffered_request_bytes gauge")
+	fmt.Fprintf(w, "lblight_buffered_request_bytes %d\n", stats.BufferedBytes)
+
+	fmt.Fprintln(w, "# HELP lblight_backend_breaker_state Circuit breaker state per backend (0 closed, 1 open, 2 half-open).")
+	fmt.Fprintln(w, "# TYPE lblight_backend_breaker_state gauge")
+	for _, rs := range stats.Routers {
+		for _, bs := range rs.Backends {
+			if bs.BreakerState == "" {
+				continue
+			}
+			fmt.Fprintf(w, "lblight_backend_breaker_state{router=%q,backend=%q} %d\n", rs.Router, bs.ID, breakerStateValue(bs.BreakerState))
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP lblight_backend_breaker_trips_total Number of times the backends circuit breaker has opened.")
+	fmt.Fprintln(w, "# TYPE lblight_backend_breaker_trips_total counter")
+	for _, rs := range stats.Routers {
+		for _, bs := range rs.Backends {
+			if bs.BreakerState == "" {
+				continue
+			}
+			fmt.Fprintf(w, "lblight_backend_breaker_trips_total{router=%q,backend=%q} %d\n", rs.Router, bs.ID, bs.BreakerTrips)
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP lblight_backend_health_score Health score per backend, from 0 to 1 (healthy).")
+	fmt.Fprintln(w, "# TYPE lblight_backend_health_score gauge")
+	for _, rs := range stats.Routers {
+		for _, bs := range rs.Backends {
+			fmt.Fprintf(w, "lblight_backend_health_score{router=%q,backend=%q} %g\n", rs.Router, bs.ID, bs.HealthScore)
+		}
+	}
+
+	writeResponseSizeMetrics(w, stats)
+}
+
+// writeConnectionMetrics writes the client connection counters and gauges.
+func writeConnectionMetrics(w io.Writer, cs ConnectionStats) {
+	fmt.Fprintln(w, "# HELP lblight_connections_accepted_total Client connections accepted.")
+	fmt.Fprintln(w, "# TYPE lblight_connections_accepted_total counter")
+	fmt.Fprintf(w, "lblight_connections_accepted_total %d\n", cs.Accepted)
+	fmt.Fprintln(w, "# HELP lblight_connections_closed_total Client connections closed (or hijacked).")
+	fmt.Fprintln(w, "# TYPE lblight_connections_closed_total counter")
+	fmt.Fprintf(w, "lblight_connections_closed_total %d\n", cs.Closed)
+	fmt.Fprintln(w, "# HELP lblight_connections Open client connections by state.")
+	fmt.Fprintln(w, "# TYPE lblight_connections gauge")
+	fmt.Fprintf(w, "lblight_connections{state=\"new\"} %d\n", cs.New)
+	fmt.Fprintf(w, "lblight_connections{state=\"active\"} %d\n", cs.Active)
+	fmt.Fprintf(w, "lblight_connections{state=\"idle\"} %d\n", cs.Idle)
+}
+
+// writeResponseSizeMetrics writes the per backend response size histograms.
+func writeResponseSizeMetrics(w io.Writer, stats Stats) {
+	fmt.Fprintln(w, "# HELP lblight_backend_response_size_bytes Size of response bodies proxied from the backend.")
+	fmt.Fprintln(w, "# TYPE lblight_backend_response_size_bytes histogram")
+	for _, rs := range stats.Routers {
+		for _, bs := range rs.Backends {
+			hs := bs.ResponseSizes
+			for i, upper := range hs.Buckets {
+				fmt.Fprintf(w, "lblight_backend_response_size_bytes_bucket{router=%q,backend=%q,le=\"%d\"} %d\n", rs.Router, bs.ID, upper, hs.Counts[i])
+			}
+			fmt.Fprintf(w, "lblight_backend_response_size_bytes_bucket{router=%q,backend=%q,le=\"+Inf\"} %d\n", rs.Router, bs.ID, hs.Count)
+			fmt.Fprintf(w, "lblight_backend_response_size_bytes_sum{router=%q,backend=%q} %d\n", rs.Router, bs.ID, hs.Sum)
+			fmt.Fprintf(w, "lblight_backend_response_size_bytes_count{router=%q,backend=%q} %d\n", rs.Router, bs.ID, hs.Count)
+		}
+	}
+}
+
+func breakerStateValue(state string) int {
+	switch state {
+	case BreakerOpen.String():
+		return int(BreakerOpen)
+	case BreakerHalfOpen.String():
+		return int(BreakerHalfOpen)
+	}
+	return int(BreakerClosed)
+}
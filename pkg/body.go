@@ -0,0 +1,122 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrBufferBudgetExceeded is returned when buffering a request body would take the LB over its
+// BufferBudget.
+var ErrBufferBudgetExceeded = errors.New("request body buffer budget exceeded")
+
+// bodies of unknown length are reserved against the budget this much at a time as they're read.
+const bufferChunkSize = 32 * 1024
+
+// bufferRequestBody reads the whole request body into memory so it can be replayed. GetBody is
+// set, which also lets the transport resend the request if a reused keep-alive connection turns
+// out to be dead.
+func bufferRequestBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+	setBufferedBody(req, body)
+	return nil
+}
+
+// setBufferedBody replaces the request body with the buffered copy.
+func setBufferedBody(req *http.Request, body []byte) {
+	// length is known now, so send it with a Content-Length rather than chunked.
+	req.ContentLength = int64(len(body))
+	req.TransferEncoding = nil
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.Body, _ = req.GetBody()
+}
+
+// reserveBuffer takes n bytes from the BufferBudget, returning false if there isn't enough left.
+func (l *LBLight) reserveBuffer(n int64) bool {
+	if atomic.AddInt64(&l.bufferedBytes, n) > l.BufferBudget {
+		atomic.AddInt64(&l.bufferedBytes, -n)
+		return false
+	}
+	return true
+}
+
+// bufferRequestBody buffers the request body (see bufferRequestBody) counting it against the
+// BufferBudget, if there is one. release must be called once the request is done with the body.
+// If the body won't fit in the budget ErrBufferBudgetExceeded is returned and the request is
+// left to stream (whatever was read so far is put back in front of the rest).
+func (l *LBLight) bufferRequestBody(req *http.Request) (release func(), err error) {
+	release = func() {}
+	if l.BufferBudget <= 0 {
+		return release, bufferRequestBody(req)
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return release, nil
+	}
+
+	// known length can be reserved up front.
+	if req.ContentLength > 0 {
+		reserved := req.ContentLength
+		if !l.reserveBuffer(reserved) {
+			return release, ErrBufferBudgetExceeded
+		}
+		release = func() { atomic.AddInt64(&l.bufferedBytes, -reserved) }
+		if err := bufferRequestBody(req); err != nil {
+			release()
+			return func() {}, err
+		}
+		return release, nil
+	}
+
+	// otherwise reserve as it's read.
+	reserved := int64(0)
+	giveBack := func(n int64) {
+		atomic.AddInt64(&l.bufferedBytes, -n)
+		reserved -= n
+	}
+
+	buf := bytes.Buffer{}
+	for {
+		if int64(buf.Len()) >= reserved {
+			if !l.reserveBuffer(bufferChunkSize) {
+				giveBack(reserved)
+				req.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(buf.Bytes()), req.Body), req.Body}
+				return release, ErrBufferBudgetExceeded
+			}
+			reserved += bufferChunkSize
+		}
+
+		_, err = io.CopyN(&buf, req.Body, reserved-int64(buf.Len()))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			giveBack(reserved)
+			req.Body.Close()
+			return release, err
+		}
+	}
+	req.Body.Close()
+
+	// hand back any of the reservation not needed.
+	giveBack(reserved - int64(buf.Len()))
+	setBufferedBody(req, buf.Bytes())
+
+	held := reserved
+	return func() { atomic.AddInt64(&l.bufferedBytes, -held) }, nil
+}
@@ -0,0 +1,113 @@
+package pkg
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestUnbufferedUploadStreams(t *testing.T) {
+	const chunk = 64 * 1024
+	const chunks = 64
+	firstChunk := make(chan struct{})
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, chunk)
+		if _, err := io.ReadFull(r.Body, buf); err != nil {
+			t.Errorf("reading first chunk: %s", err)
+			return
+		}
+		close(firstChunk)
+		n, _ := io.Copy(ioutil.Discard, r.Body)
+		w.Write([]byte(strconv.FormatInt(n+chunk, 10)))
+	})
+
+	l := NewLBLight(0)
+	addRouter(t, l, routerFor(t, backend, "/"))
+	lb := serveLB(t, l)
+
+	// the rest of the upload is only sent once the backend has the first chunk, which would
+	// never happen if the LB waited for the whole body.
+	pr, pw := io.Pipe()
+	go func() {
+		data := bytes.Repeat([]byte("x"), chunk)
+		pw.Write(data)
+		select {
+		case <-firstChunk:
+		case <-time.After(5 * time.Second):
+			pw.CloseWithError(io.ErrUnexpectedEOF)
+			return
+		}
+		for i := 1; i < chunks; i++ {
+			pw.Write(data)
+		}
+		pw.Close()
+	}()
+
+	req, _ := http.NewRequest(http.MethodPost, lb.URL+"/upload", pr)
+	resp, body := doRequest(t, req)
+	if resp.StatusCode != http.StatusOK || body != strconv.Itoa(chunk*chunks) {
+		t.Errorf("expected the backend to stream all %d bytes, got %d %q", chunk*chunks, resp.StatusCode, body)
+	}
+}
+
+func TestBufferBudgetCapsBufferedBytes(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/hold" {
+			started <- struct{}{}
+			<-release
+		}
+		w.Write([]byte(strconv.Itoa(len(body))))
+	})
+
+	for _, reject := range []bool{true, false} {
+		l := NewLBLight(0)
+		l.BufferBudget = 1000
+		l.RejectOverBufferBudget = reject
+		ber := routerFor(t, backend, "/")
+		ber.BufferRequestBody = true
+		addRouter(t, l, ber)
+		lb := serveLB(t, l)
+
+		held := make(chan struct{})
+		go func() {
+			defer close(held)
+			resp, err := http.Post(lb.URL+"/hold", "text/plain", bytes.NewReader(make([]byte, 800)))
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		<-started
+		if buffered := l.Stats().BufferedBytes; buffered != 800 {
+			t.Errorf("expected 800 bytes buffered, got %d", buffered)
+		}
+
+		// over budget, rejected or streamed.
+		req, _ := http.NewRequest(http.MethodPost, lb.URL+"/", bytes.NewReader(make([]byte, 500)))
+		resp, body := doRequest(t, req)
+		if reject && resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("expected 503 over the budget, got %d", resp.StatusCode)
+		}
+		if !reject && (resp.StatusCode != http.StatusOK || body != "500") {
+			t.Errorf("expected the body streamed over the budget, got %d %q", resp.StatusCode, body)
+		}
+
+		// still fits.
+		req, _ = http.NewRequest(http.MethodPost, lb.URL+"/", bytes.NewReader(make([]byte, 100)))
+		if resp, body := doRequest(t, req); resp.StatusCode != http.StatusOK || body != "100" {
+			t.Errorf("expected a body within the budget to get through, got %d %q", resp.StatusCode, body)
+		}
+
+		release <- struct{}{}
+		<-held
+		waitFor(t, "the budget to be given back", func() bool {
+			return l.Stats().BufferedBytes == 0
+		})
+	}
+}
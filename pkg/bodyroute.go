@@ -0,0 +1,122 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+)
+
+// BodyRouter routes requests on a value extracted from the request body, eg the target service of
+// an RPC-over-HTTP call. Routers accept values with AcceptedBodyValues.
+// The body is peeked (up to MaxBytes) before routing and replayed to the backend untouched. Peeks
+// count against the LBs BufferBudget, a body that won't fit isn't routed on.
+type BodyRouter struct {
+	// MaxBytes is the most of the body read for routing. Bigger bodies aren't routed on their
+	// content (but are still proxied). Default 64KB.
+	MaxBytes int64
+
+	// Extract returns the routing value from the body, or "" if there isn't one.
+	Extract func(body []byte) string
+}
+
+func (br *BodyRouter) maxBytes() int64 {
+	if br.MaxBytes <= 0 {
+		return 64 * 1024
+	}
+	return br.MaxBytes
+}
+
+type bodyRouteKey struct{}
+
+// peekBody reads the start of the request body, extracts the routing value into the request
+// context and puts the body back for the backend.
+func (br *BodyRouter) peekBody(req *http.Request) *http.Request {
+	if br.Extract == nil || req.Body == nil || req.Body == http.NoBody {
+		return req
+	}
+
+	body := req.Body
+	peeked, err := ioutil.ReadAll(io.LimitReader(body, br.maxBytes()+1))
+	if err != nil || int64(len(peeked)) > br.maxBytes() {
+		if err != nil {
+			log.Warnf("Unable to read body for routing %s : %s", req.RequestURI, err.Error())
+		}
+		// put back what was read, ahead of the rest.
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(peeked), body), body}
+		return req
+	}
+
+	// have the whole thing, so it can be replayed too.
+	body.Close()
+	req.ContentLength = int64(len(peeked))
+	req.TransferEncoding = nil
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(peeked)), nil
+	}
+	req.Body, _ = req.GetBody()
+
+	value := br.Extract(peeked)
+	if value == "" {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), bodyRouteKey{}, value))
+}
+
+// peekRequestBody peeks the body for the BodyRouter (see peekBody), counting what it holds against
+// the BufferBudget like a buffered body. release must be called once the request is done with it.
+// If the peek won't fit in the budget ErrBufferBudgetExceeded is returned and the body is left
+// unread, so isn't routed on.
+func (l *LBLight) peekRequestBody(req *http.Request) (_ *http.Request, release func(), err error) {
+	release = func() {}
+	br := l.BodyRouter
+	if l.BufferBudget <= 0 || br.Extract == nil || req.Body == nil || req.Body == http.NoBody {
+		return br.peekBody(req), release, nil
+	}
+
+	reserved := br.maxBytes() + 1
+	if req.ContentLength > 0 && req.ContentLength < reserved {
+		reserved = req.ContentLength
+	}
+	if !l.reserveBuffer(reserved) {
+		return req, release, ErrBufferBudgetExceeded
+	}
+
+	req = br.peekBody(req)
+	// read the whole thing, hand back any of the reservation not needed.
+	if req.GetBody != nil && req.ContentLength < reserved {
+		atomic.AddInt64(&l.bufferedBytes, req.ContentLength-reserved)
+		reserved = req.ContentLength
+	}
+	held := reserved
+	return req, func() { atomic.AddInt64(&l.bufferedBytes, -held) }, nil
+}
+
+// bodyRouteValue returns the routing value peekBody extracted from the request, if any.
+func bodyRouteValue(req *http.Request) string {
+	value, _ := req.Context().Value(bodyRouteKey{}).(string)
+	return value
+}
+
+func (l *LBLight) matchBodyRoute(req *http.Request) *BackendRouter {
+	value := bodyRouteValue(req)
+	if value == "" {
+		return nil
+	}
+	if router, ok := l.bodyValueToBackendRouter[value]; ok && router.matches(req) {
+		return router
+	}
+	return nil
+}
+
+func (l *LBLight) unregisterBodyValues(values []string) {
+	for _, value := range values {
+		delete(l.bodyValueToBackendRouter, value)
+	}
+}
@@ -0,0 +1,124 @@
+package pkg
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func echoBody(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(name + ":" + string(body)))
+	}
+}
+
+func TestBodyRouting(t *testing.T) {
+	billing := newBackendServer(t, echoBody("billing"))
+	other := newBackendServer(t, echoBody("other"))
+
+	l := NewLBLight(0)
+	l.BodyRouter = &BodyRouter{Extract: func(body []byte) string {
+		var call struct {
+			Service string `json:"service"`
+		}
+		json.Unmarshal(body, &call)
+		return call.Service
+	}}
+	host, port := hostPort(t, billing)
+	billingRouter := NewBackendRouter(host, port, nil, nil, 10)
+	billingRouter.AcceptedBodyValues = []string{"billing"}
+	addRouter(t, l, billingRouter)
+	addRouter(t, l, routerFor(t, other, "/"))
+	lb := serveLB(t, l)
+
+	for _, tt := range []struct {
+		body     string
+		expected string
+	}{
+		{`{"service":"billing","amount":42}`, "billing"},
+		{`{"service":"shipping"}`, "other"},
+		{`not json`, "other"},
+	} {
+		req, _ := http.NewRequest(http.MethodPost, lb.URL+"/rpc", strings.NewReader(tt.body))
+		resp, body := doRequest(t, req)
+		if expected := tt.expected + ":" + tt.body; resp.StatusCode != http.StatusOK || body != expected {
+			t.Errorf("body %s: expected %q, got %d %q", tt.body, expected, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestBodyRoutingCountsAgainstBufferBudget(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	billing := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hold" {
+			started <- struct{}{}
+			<-release
+		}
+		echoBody("billing")(w, r)
+	})
+	other := newBackendServer(t, echoBody("other"))
+
+	call := func(size int) string {
+		return `{"service":"billing","pad":"` + strings.Repeat("x", size-len(`{"service":"billing","pad":""}`)) + `"}`
+	}
+
+	for _, reject := range []bool{true, false} {
+		l := NewLBLight(0)
+		l.BufferBudget = 1000
+		l.RejectOverBufferBudget = reject
+		l.BodyRouter = &BodyRouter{Extract: func(body []byte) string {
+			var call struct {
+				Service string `json:"service"`
+			}
+			json.Unmarshal(body, &call)
+			return call.Service
+		}}
+		host, port := hostPort(t, billing)
+		billingRouter := NewBackendRouter(host, port, nil, nil, 10)
+		billingRouter.AcceptedBodyValues = []string{"billing"}
+		addRouter(t, l, billingRouter)
+		addRouter(t, l, routerFor(t, other, "/"))
+		lb := serveLB(t, l)
+
+		held := make(chan struct{})
+		go func() {
+			defer close(held)
+			resp, err := http.Post(lb.URL+"/hold", "application/json", strings.NewReader(call(800)))
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		<-started
+		if buffered := l.Stats().BufferedBytes; buffered != 800 {
+			t.Errorf("expected the 800 byte peek counted, got %d", buffered)
+		}
+
+		// over budget, rejected or not routed on its body.
+		body := call(500)
+		req, _ := http.NewRequest(http.MethodPost, lb.URL+"/rpc", strings.NewReader(body))
+		resp, got := doRequest(t, req)
+		if reject && resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("expected 503 over the budget, got %d", resp.StatusCode)
+		}
+		if !reject && (resp.StatusCode != http.StatusOK || got != "other:"+body) {
+			t.Errorf("expected the body proxied without body routing, got %d %q", resp.StatusCode, got)
+		}
+
+		// still fits.
+		body = call(100)
+		req, _ = http.NewRequest(http.MethodPost, lb.URL+"/rpc", strings.NewReader(body))
+		if resp, got := doRequest(t, req); resp.StatusCode != http.StatusOK || got != "billing:"+body {
+			t.Errorf("expected a body within the budget routed, got %d %q", resp.StatusCode, got)
+		}
+
+		release <- struct{}{}
+		<-held
+		waitFor(t, "the budget to be given back", func() bool {
+			return l.Stats().BufferedBytes == 0
+		})
+	}
+}
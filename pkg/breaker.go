@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a backends circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed means traffic flows as normal.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen means the backend has failed too often and gets no traffic.
+	BreakerOpen
+
+	// BreakerHalfOpen means the open period has passed and the next request is a trial. Only the
+	// one trial is let through until it's done.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerConfig configures the per backend circuit breakers of a BackendRouter.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures (proxy errors or 5xx) before the breaker opens.
+	FailureThreshold int
+
+	// OpenDuration is how long the breaker stays open before letting a trial request through.
+	OpenDuration time.Duration
+}
+
+// circuitBreaker tracks consecutive failures for a single backend.
+type circuitBreaker struct {
+	config CircuitBreakerConfig
+
+	mux                 sync.Mutex
+	state               BreakerState
+	consecutiveFailures int
+	openedAt            time.Time
+
+	// a half-open trial request is in flight.
+	trial bool
+
+	// number of times the breaker has gone from closed/half-open to open.
+	trips int64
+}
+
+func newCircuitBreaker(config CircuitBreakerConfig) *circuitBreaker {
+	cb := circuitBreaker{}
+	cb.config = config
+	cb.state = BreakerClosed
+	return &cb
+}
+
+// allow reports if a request can be sent to the backend. An open breaker moves to
+// half-open once OpenDuration has passed, then allows one trial at a time (see begin).
+func (cb *circuitBreaker) allow() bool {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	if cb.state == BreakerOpen && time.Since(cb.openedAt) >= cb.config.OpenDuration {
+		cb.state = BreakerHalfOpen
+	}
+	if cb.state == BreakerHalfOpen {
+		return !cb.trial
+	}
+	return cb.state != BreakerOpen
+}
+
+// begin is called when a request is sent to the backend. While half-open that request is the
+// trial and allow refuses any others until end.
+func (cb *circuitBreaker) begin() {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	if cb.state == BreakerHalfOpen {
+		cb.trial = true
+	}
+}
+
+// end is called once the request is done with the backend, whatever the outcome. A trial that
+// didn't record a result (eg the client went away) lets the next request be the trial instead.
+func (cb *circuitBreaker) end() {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+	cb.trial = false
+}
+
+func (cb *circuitBreaker) recordSuccess() {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	cb.consecutiveFailures = 0
+	cb.state = BreakerClosed
+	cb.trial = false
+}
+
+func (cb *circuitBreaker) recordFailure() {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	cb.consecutiveFailures++
+	if cb.state == BreakerHalfOpen || (cb.state == BreakerClosed && cb.consecutiveFailures >= cb.config.FailureThreshold) {
+		cb.state = BreakerOpen
+		cb.openedAt = time.Now()
+		cb.trips++
+		cb.trial = false
+	}
+}
+
+// snapshot returns current state and trip count.
+func (cb *circuitBreaker) snapshot() (BreakerState, int64) {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	if cb.state == BreakerOpen && time.Since(cb.openedAt) >= cb.config.OpenDuration {
+		return BreakerHalfOpen, cb.trips
+	}
+	return cb.state, cb.trips
+}
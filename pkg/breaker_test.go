@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBreakerHalfOpenAllowsOneTrial(t *testing.T) {
+	cb := newCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: time.Millisecond})
+	cb.recordFailure()
+	if cb.allow() {
+		t.Fatal("open breaker allowed a request")
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	if !cb.allow() {
+		t.Fatal("half-open breaker refused the trial")
+	}
+	cb.begin()
+	if cb.allow() {
+		t.Error("half-open breaker allowed a second request while the trial is in flight")
+	}
+
+	// trial finished without a result, the next request gets to be the trial.
+	cb.end()
+	if !cb.allow() {
+		t.Error("half-open breaker refused a new trial after the last one ended")
+	}
+
+	cb.begin()
+	cb.recordSuccess()
+	cb.end()
+	if state, _ := cb.snapshot(); state != BreakerClosed {
+		t.Errorf("expected closed after a successful trial, got %s", state)
+	}
+	if !cb.allow() || !cb.allow() {
+		t.Error("closed breaker refused requests")
+	}
+}
+
+func TestBreakerTripShowsInStatsAndMetrics(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.maxBackends = 1
+	ber.FailurePolicy = &FailurePolicy{CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 2, OpenDuration: time.Minute}}
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	for i := 0; i < 2; i++ {
+		if resp, _ := get(t, lb.URL+"/"); resp.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("expected the backends 500, got %d", resp.StatusCode)
+		}
+	}
+	if resp, _ := get(t, lb.URL+"/"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 with the breaker open, got %d", resp.StatusCode)
+	}
+
+	admin := httptest.NewServer(l.AdminHandler())
+	defer admin.Close()
+
+	_, body := get(t, admin.URL+"/stats")
+	var stats Stats
+	if err := json.Unmarshal([]byte(body), &stats); err != nil {
+		t.Fatalf("bad /stats JSON: %s", err)
+	}
+	if len(stats.Routers) != 1 || len(stats.Routers[0].Backends) != 1 {
+		t.Fatalf("expected one router with one backend, got %+v", stats.Routers)
+	}
+	bs := stats.Routers[0].Backends[0]
+	if bs.BreakerState != "open" || bs.BreakerTrips != 1 {
+		t.Errorf("expected an open breaker tripped once, got %s with %d trips", bs.BreakerState, bs.BreakerTrips)
+	}
+
+	_, metrics := get(t, admin.URL+"/metrics")
+	labels := fmt.Sprintf("{router=%q,backend=%q}", ber.String(), bs.ID)
+	for _, want := range []string{
+		"lblight_backend_breaker_state" + labels + " 1",
+		"lblight_backend_breaker_trips_total" + labels + " 1",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("/metrics missing %q", want)
+		}
+	}
+}
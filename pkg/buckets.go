@@ -0,0 +1,115 @@
+package pkg
+
+import (
+	"hash/fnv"
+	"net/http"
+	"text/template"
+)
+
+// HeaderBuckets splits a routers traffic between variant routers (eg for A/B testing) by hashing
+// the value of a header into buckets. The same header value always lands in the same bucket, so
+// a given user sticks to the same variant.
+type HeaderBuckets struct {
+	// Header to hash, eg "X-User-ID". Requests without it stay on the original router.
+	Header string
+
+	// Buckets is the number of buckets to hash into. Defaults to 100 so ranges can be read as percentages.
+	Buckets int
+
+	// Variants maps bucket ranges to routers. Buckets not covered by a variant stay on the original router.
+	Variants []BucketVariant
+}
+
+// BucketVariant sends buckets First to Last (inclusive) to Router.
+type BucketVariant struct {
+	First  int
+	Last   int
+	Router *BackendRouter
+}
+
+// bucket returns the bucket for a header value.
+func (hb *HeaderBuckets) bucket(value string) int {
+	buckets := hb.Buckets
+	if buckets <= 0 {
+		buckets = 100
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(value))
+	return int(h.Sum32() % uint32(buckets))
+}
+
+// variantRouter returns the router the request should go to, or nil if it isn't covered by a variant.
+func (hb *HeaderBuckets) variantRouter(req *http.Request) *BackendRouter {
+	value := req.Header.Get(hb.Header)
+	if value == "" {
+		return nil
+	}
+
+	bucket := hb.bucket(value)
+	for _, variant := range hb.Variants {
+		if bucket >= variant.First && bucket <= variant.Last {
+			return variant.Router
+		}
+	}
+	return nil
+}
+
+// variantRouters returns the routers ber hands requests off to.
+func (ber *BackendRouter) variantRouters() []*BackendRouter {
+	if ber.HeaderBuckets == nil {
+		return nil
+	}
+	routers := []*BackendRouter{}
+	for _, variant := range ber.HeaderBuckets.Variants {
+		if variant.Router != nil && variant.Router != ber {
+			routers = append(routers, variant.Router)
+		}
+	}
+	return routers
+}
+
+type listedVariant struct {
+	router      *BackendRouter
+	logTemplate *template.Template
+}
+
+// checkVariantRouters returns the variants of ber that haven't been added to the LB themselves,
+// with their LogFormat compiled. Caller must hold the lock.
+func (l *LBLight) checkVariantRouters(ber *BackendRouter) ([]listedVariant, error) {
+	variants := []listedVariant{}
+	for _, router := range ber.variantRouters() {
+		if l.isListed(router) {
+			continue
+		}
+		logTemplate, err := router.compileLogFormat()
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, listedVariant{router: router, logTemplate: logTemplate})
+	}
+	return variants, nil
+}
+
+// listVariantRouters adds the checked variants to the routers, so they're reported and shut down
+// like any other. They aren't registered for any routes, they only get the requests handed to
+// them. Caller must hold the lock.
+func (l *LBLight) listVariantRouters(variants []listedVariant) {
+	for _, variant := range variants {
+		if l.isListed(variant.router) {
+			continue
+		}
+		variant.router.logTemplate = variant.logTemplate
+		l.routers = append(l.routers, variant.router)
+	}
+}
+
+// isListed reports if the router has been added to the LB. Caller must hold the lock.
+func (l *LBLight) isListed(ber *BackendRouter) bool {
+	for _, router := range l.routers {
+		if router == ber {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHeaderBucketsStableAndSplit(t *testing.T) {
+	variantA := NewBackendRouter("127.0.0.1", 9001, nil, nil, 1)
+	variantB := NewBackendRouter("127.0.0.1", 9002, nil, nil, 1)
+	hb := &HeaderBuckets{
+		Header: "X-User-ID",
+		Variants: []BucketVariant{
+			{First: 0, Last: 19, Router: variantA},
+			{First: 20, Last: 49, Router: variantB},
+		},
+	}
+
+	requestFor := func(user string) *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "http://lb/", nil)
+		req.Header.Set("X-User-ID", user)
+		return req
+	}
+
+	const users = 20000
+	counts := make(map[*BackendRouter]int)
+	for i := 0; i < users; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		variant := hb.variantRouter(requestFor(user))
+		for j := 0; j < 3; j++ {
+			if again := hb.variantRouter(requestFor(user)); again != variant {
+				t.Fatalf("%s moved variant between requests", user)
+			}
+		}
+		counts[variant]++
+	}
+
+	// nil is the original router, for the buckets no variant covers.
+	for _, tt := range []struct {
+		name    string
+		router  *BackendRouter
+		percent int
+	}{
+		{"variant A", variantA, 20},
+		{"variant B", variantB, 30},
+		{"original", nil, 50},
+	} {
+		got := float64(counts[tt.router]) * 100 / users
+		if got < float64(tt.percent)-2 || got > float64(tt.percent)+2 {
+			t.Errorf("%s got %.1f%% of users, expected about %d%%", tt.name, got, tt.percent)
+		}
+	}
+
+	if hb.variantRouter(requestFor("")) != nil {
+		t.Error("request without the header went to a variant")
+	}
+}
+
+func TestHeaderBucketsVariantsStarted(t *testing.T) {
+	original := newBackendServer(t, textHandler("original"))
+	variant := newBackendServer(t, textHandler("variant"))
+
+	resolver := &stubResolver{}
+	resolver.set(variant.URL)
+	host, port := hostPort(t, variant)
+	variantRouter := NewBackendRouter(host, port, nil, nil, 10)
+	variantRouter.Resolver = resolver
+	variantRouter.ResolveInterval = 10 * time.Millisecond
+	variantRouter.HealthCheck = &HealthCheckConfig{Path: "/healthz", Interval: 10 * time.Millisecond}
+
+	l := NewLBLight(0)
+	ber := routerFor(t, original, "/")
+	ber.HeaderBuckets = &HeaderBuckets{
+		Header:   "X-User-ID",
+		Variants: []BucketVariant{{First: 0, Last: 99, Router: variantRouter}},
+	}
+	addRouter(t, l, ber)
+	defer variantRouter.Close()
+	lb := serveLB(t, l)
+
+	if ids := backendIDs(variantRouter); len(ids) != 1 || ids[variant.URL] == "" {
+		t.Fatalf("expected the variants backends resolved, got %v", ids)
+	}
+	waitFor(t, "the variants backend to pass its health check", func() bool {
+		backends := variantRouter.stats().Backends
+		return len(backends) == 1 && backends[0].Alive
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, lb.URL+"/", nil)
+	req.Header.Set("X-User-ID", "user-1")
+	if resp, body := doRequest(t, req); resp.StatusCode != http.StatusOK || body != "variant" {
+		t.Errorf("expected the variant to serve the request, got %d %q", resp.StatusCode, body)
+	}
+
+	resolver.set(variant.URL, "http://10.0.0.1:8080")
+	waitFor(t, "the variants backends to be re-resolved", func() bool {
+		return len(backendIDs(variantRouter)) == 2
+	})
+
+	listed := false
+	for _, rs := range l.Stats().Routers {
+		listed = listed || rs.Router == variantRouter.String()
+	}
+	if !listed {
+		t.Error("expected the variant listed in the stats")
+	}
+}
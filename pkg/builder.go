@@ -0,0 +1,46 @@
+package pkg
+
+// RouterBuilder builds a BackendRouter without having to put together the path and header maps
+// for NewBackendRouter by hand, eg
+//
+//	ber := NewRouterBuilder("127.0.0.1", 8081).WithPath("/api").WithHeader("X-Env", "prod").MaxBackends(4).Build()
+type RouterBuilder struct {
+	host            string
+	port            int
+	acceptedHeaders map[string]string
+	acceptedPaths   map[string]bool
+	maxBackends     int
+}
+
+func NewRouterBuilder(host string, port int) *RouterBuilder {
+	rb := RouterBuilder{}
+	rb.host = host
+	rb.port = port
+	rb.acceptedHeaders = make(map[string]string)
+	rb.acceptedPaths = make(map[string]bool)
+	return &rb
+}
+
+// WithPath adds a path prefix the router accepts.
+func (rb *RouterBuilder) WithPath(path string) *RouterBuilder {
+	rb.acceptedPaths[path] = true
+	return rb
+}
+
+// WithHeader adds a header and value the router accepts. Setting the same header again replaces the value.
+func (rb *RouterBuilder) WithHeader(header string, value string) *RouterBuilder {
+	rb.acceptedHeaders[header] = value
+	return rb
+}
+
+// MaxBackends sets the maximum number of backends the router will create.
+func (rb *RouterBuilder) MaxBackends(maxBackends int) *RouterBuilder {
+	rb.maxBackends = maxBackends
+	return rb
+}
+
+// Build creates the router via NewBackendRouter. The builder shouldn't be reused afterwards since
+// the router shares its maps.
+func (rb *RouterBuilder) Build() *BackendRouter {
+	return NewBackendRouter(rb.host, rb.port, rb.acceptedHeaders, rb.acceptedPaths, rb.maxBackends)
+}
@@ -0,0 +1,30 @@
+package pkg
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRouterBuilderMatchesNewBackendRouter(t *testing.T) {
+	built := NewRouterBuilder("127.0.0.1", 8081).WithPath("/api").WithPath("/v2").WithHeader("X-Env", "staging").WithHeader("X-Env", "prod").MaxBackends(4).Build()
+	direct := NewBackendRouter("127.0.0.1", 8081, map[string]string{"X-Env": "prod"}, map[string]bool{"/api": true, "/v2": true}, 4)
+
+	if built.String() != direct.String() || built.maxBackends != direct.maxBackends {
+		t.Errorf("expected %s with max %d, got %s with max %d", direct.String(), direct.maxBackends, built.String(), built.maxBackends)
+	}
+	if !reflect.DeepEqual(built.acceptedHeaders, direct.acceptedHeaders) || !reflect.DeepEqual(built.acceptedPaths, direct.acceptedPaths) {
+		t.Errorf("expected headers %v and paths %v, got %v and %v", direct.acceptedHeaders, direct.acceptedPaths, built.acceptedHeaders, built.acceptedPaths)
+	}
+
+	builtLB, directLB := NewLBLight(0), NewLBLight(0)
+	addRouter(t, builtLB, built)
+	addRouter(t, directLB, direct)
+	t.Cleanup(func() {
+		builtLB.Shutdown(context.Background())
+		directLB.Shutdown(context.Background())
+	})
+	if !reflect.DeepEqual(builtLB.Routes(), directLB.Routes()) {
+		t.Errorf("expected routes %+v, got %+v", directLB.Routes(), builtLB.Routes())
+	}
+}
@@ -0,0 +1,141 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// coalescedCall is an upstream request that identical requests are waiting on.
+type coalescedCall struct {
+	done     chan struct{}
+	response *StaticResponse
+	waiters  int
+}
+
+// coalescer tracks the in flight coalesced calls for a router, keyed by coalesceKey.
+type coalescer struct {
+	mux   sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// recordingResponseWriter captures a (whole) response so it can be sent to every waiter.
+type recordingResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *recordingResponseWriter) WriteHeader(status int) {
+	// 1xx are informational, the real status is still to come.
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+}
+
+func (w *recordingResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(p)
+}
+
+// coalesceHeaders are the request headers responses commonly Vary on, requests only share a
+// response if these match too.
+var coalesceHeaders = []string{"Accept", "Accept-Encoding", "Accept-Language"}
+
+// coalescable reports if the request can share a response with identical requests. Only plain
+// GETs, nothing with credentials since the response may well be specific to the client, and no
+// ranges since each client wants its own part.
+func coalescable(req *http.Request) bool {
+	return req.Method == http.MethodGet && req.ContentLength == 0 &&
+		req.Header.Get("Authorization") == "" && req.Header.Get("Cookie") == "" &&
+		req.Header.Get("Range") == ""
+}
+
+func coalesceKey(req *http.Request) string {
+	key := req.Host + " " + req.URL.RequestURI()
+	for _, header := range coalesceHeaders {
+		key += "\n" + strings.Join(req.Header.Values(header), ", ")
+	}
+	return key
+}
+
+// coalesce proxies the request unless an identical one is already in flight, in which case it waits
+// for and sends that requests response instead. The response is held in memory to fan it out. The
+// client that started the call giving up doesn't cancel it, the others are still waiting.
+func (l *LBLight) coalesce(res http.ResponseWriter, req *http.Request, backendRouter *BackendRouter) {
+	c := backendRouter.coalescer()
+	key := coalesceKey(req)
+
+	c.mux.Lock()
+	if call, ok := c.calls[key]; ok {
+		call.waiters++
+		c.mux.Unlock()
+
+		select {
+		case <-call.done:
+			// a cookie is for one client, go to the backend for our own.
+			if call.response.Header.Get("Set-Cookie") != "" {
+				l.proxy(res, req, backendRouter)
+				return
+			}
+			call.response.write(res)
+		case <-req.Context().Done():
+			log.Debugf("Client gave up waiting on coalesced request for URL %s", req.RequestURI)
+		}
+		return
+	}
+	call := &coalescedCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mux.Unlock()
+
+	rec := &recordingResponseWriter{header: make(http.Header)}
+
+	// always let the waiters go, including when the proxy panics (backend died mid-body).
+	defer func() {
+		c.mux.Lock()
+		delete(c.calls, key)
+		waiters := call.waiters
+		c.mux.Unlock()
+
+		if call.response == nil {
+			call.response = &StaticResponse{StatusCode: http.StatusBadGateway}
+		}
+		close(call.done)
+		if waiters > 0 {
+			log.Debugf("Coalesced %d requests for URL %s", waiters, req.RequestURI)
+		}
+	}()
+
+	// the call is shared, so it carries on if this client goes away. Its deadline still applies.
+	ctx := context.WithoutCancel(req.Context())
+	if deadline, ok := req.Context().Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	l.proxy(rec, req.WithContext(ctx), backendRouter)
+
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	call.response = &StaticResponse{StatusCode: status, Header: rec.header, Body: rec.body.Bytes()}
+	call.response.write(res)
+}
+
+// coalescer returns the routers coalescer, creating it on first use.
+func (ber *BackendRouter) coalescer() *coalescer {
+	ber.coalescerOnce.Do(func() {
+		ber.coalesced = &coalescer{calls: make(map[string]*coalescedCall)}
+	})
+	return ber.coalesced
+}
@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gatedCoalescingLB serves a coalescing router whose backend holds every request until release is
+// closed, counting them in hits.
+func gatedCoalescingLB(t *testing.T, handler http.HandlerFunc) (string, *BackendRouter, *int32, func()) {
+	t.Helper()
+	gate := make(chan struct{})
+	var once sync.Once
+	release := func() { once.Do(func() { close(gate) }) }
+
+	hits := new(int32)
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		<-gate
+		handler(w, r)
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.CoalesceRequests = true
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+	// registered last so it runs first, the servers can't close with requests held.
+	t.Cleanup(release)
+	return lb.URL, ber, hits, release
+}
+
+// waiters returns how many requests are waiting on the in flight coalesced calls.
+func waiters(ber *BackendRouter) int {
+	c := ber.coalescer()
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	n := 0
+	for _, call := range c.calls {
+		n += call.waiters
+	}
+	return n
+}
+
+type coalescedResult struct {
+	status int
+	body   string
+	cookie string
+}
+
+// sendConcurrently sends n GETs with the given header at once, the results arrive on the channel.
+func sendConcurrently(t *testing.T, uri string, n int, header http.Header) chan coalescedResult {
+	results := make(chan coalescedResult, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			req, _ := http.NewRequest(http.MethodGet, uri, nil)
+			for name, values := range header {
+				req.Header[name] = values
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				results <- coalescedResult{}
+				return
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			results <- coalescedResult{resp.StatusCode, string(body), resp.Header.Get("Set-Cookie")}
+		}()
+	}
+	return results
+}
+
+func TestCoalesceConcurrentGets(t *testing.T) {
+	uri, ber, hits, release := gatedCoalescingLB(t, textHandler("shared"))
+
+	const clients = 50
+	results := sendConcurrently(t, uri+"/page", clients, nil)
+	waitFor(t, "every client to join the call", func() bool { return waiters(ber) == clients-1 })
+	release()
+
+	for i := 0; i < clients; i++ {
+		if r := <-results; r.status != http.StatusOK || r.body != "shared" {
+			t.Errorf("expected every client to get the shared response, got %d %q", r.status, r.body)
+		}
+	}
+	if n := atomic.LoadInt32(hits); n != 1 {
+		t.Errorf("expected the backend to be hit once for %d clients, got %d", clients, n)
+	}
+}
+
+func TestCoalesceKeepsVaryingRequestsApart(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		headers []http.Header
+	}{
+		{"accept encoding", []http.Header{{"Accept-Encoding": {"gzip"}}, {"Accept-Encoding": {"br"}}}},
+		{"accept", []http.Header{{"Accept": {"text/html"}}, {"Accept": {"application/json"}}}},
+		{"range", []http.Header{{"Range": {"bytes=0-9"}}, {"Range": {"bytes=0-9"}}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			uri, ber, hits, release := gatedCoalescingLB(t, textHandler("ok"))
+
+			var all []chan coalescedResult
+			for _, header := range tt.headers {
+				all = append(all, sendConcurrently(t, uri+"/page", 1, header))
+			}
+			waitFor(t, "each request to reach the backend", func() bool {
+				return atomic.LoadInt32(hits) == int32(len(tt.headers))
+			})
+			if n := waiters(ber); n != 0 {
+				t.Errorf("expected no coalesced waiters, got %d", n)
+			}
+			release()
+			for _, results := range all {
+				if r := <-results; r.status != http.StatusOK {
+					t.Errorf("expected 200, got %d", r.status)
+				}
+			}
+		})
+	}
+}
+
+func TestCoalesceDoesNotShareCookies(t *testing.T) {
+	var sessions int32
+	uri, ber, hits, release := gatedCoalescingLB(t, func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: string(rune('a' + atomic.AddInt32(&sessions, 1)))})
+		w.Write([]byte("ok"))
+	})
+
+	const clients = 5
+	results := sendConcurrently(t, uri+"/login", clients, nil)
+	waitFor(t, "every client to join the call", func() bool { return waiters(ber) == clients-1 })
+	release()
+
+	cookies := make(map[string]bool)
+	for i := 0; i < clients; i++ {
+		r := <-results
+		if r.status != http.StatusOK || r.cookie == "" {
+			t.Errorf("expected 200 with a cookie, got %d %q", r.status, r.cookie)
+		}
+		cookies[r.cookie] = true
+	}
+	if len(cookies) != clients {
+		t.Errorf("expected each client to get its own cookie, got %d distinct", len(cookies))
+	}
+	if n := atomic.LoadInt32(hits); n != clients {
+		t.Errorf("expected a backend request per client, got %d", n)
+	}
+}
+
+func TestCoalesceSurvivesLeaderCancelling(t *testing.T) {
+	uri, ber, hits, release := gatedCoalescingLB(t, textHandler("shared"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		req, _ := http.NewRequest(http.MethodGet, uri+"/page", nil)
+		if resp, err := http.DefaultClient.Do(req.WithContext(ctx)); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	waitFor(t, "the leader to reach the backend", func() bool { return atomic.LoadInt32(hits) == 1 })
+
+	results := sendConcurrently(t, uri+"/page", 1, nil)
+	waitFor(t, "the waiter to join the call", func() bool { return waiters(ber) == 1 })
+
+	cancel()
+	<-leaderDone
+	// give the LB time to see the leader go.
+	time.Sleep(50 * time.Millisecond)
+	release()
+
+	if r := <-results; r.status != http.StatusOK || r.body != "shared" {
+		t.Errorf("expected the waiter to get the shared 200, got %d %q", r.status, r.body)
+	}
+	if n := atomic.LoadInt32(hits); n != 1 {
+		t.Errorf("expected the backend hit once, got %d", n)
+	}
+}
@@ -2,11 +2,125 @@ package pkg
 
 import (
 	"crypto/tls"
-	"fmt"
 	log "github.com/sirupsen/logrus"
 	"net"
+	"net/http"
+	"time"
 )
 
+// Protocols the router can use to talk to its backends, see BackendRouter.BackendProtocol.
+const (
+	// BackendProtocolAuto matches the clients protocol where possible: HTTP/2 clients are sent
+	// over HTTP/2 to TLS backends that support it, HTTP/1.x clients over HTTP/1.1.
+	BackendProtocolAuto = ""
+
+	// BackendProtocolHTTP1 always uses HTTP/1.1.
+	BackendProtocolHTTP1 = "http/1.1"
+
+	// BackendProtocolHTTP2 always uses HTTP/2, including h2c (prior knowledge) for http:// backends.
+	BackendProtocolHTTP2 = "h2"
+)
+
+// idleCloser is implemented by transports that pool connections.
+type idleCloser interface {
+	CloseIdleConnections()
+}
+
+// newTransport builds the transport shared by all backends in a router. Starts off as a copy of
+// the default transport, dialling through a resolvingDialer so backends addressed by DNS name
+// follow DNS changes. Uses the routers Transport instead if one is given.
+func (ber *BackendRouter) newTransport() http.RoundTripper {
+	if ber.Transport != nil {
+		return ber.Transport
+	}
+
+	base := http.DefaultTransport.(*http.Transport).Clone()
+
+	dialer := newResolvingDialer(ber.DNSResolver, &net.Dialer{Timeout: ber.dialTimeout(), KeepAlive: 30 * time.Second})
+	base.DialContext = dialer.DialContext
+
+	base.DisableKeepAlives = ber.DisableKeepAlives
+	base.ResponseHeaderTimeout = ber.ResponseHeaderTimeout
+
+	// Expect: 100-continue is passed through to the backend, this is how long we wait for
+	// the backends 100 Continue before sending the body anyway.
+	if ber.ExpectContinueTimeout > 0 {
+		base.ExpectContinueTimeout = ber.ExpectContinueTimeout
+	} else if ber.ExpectContinueTimeout < 0 {
+		base.ExpectContinueTimeout = 0
+	}
+
+	if ber.TLSServerName != "" {
+		if base.TLSClientConfig == nil {
+			base.TLSClientConfig = &tls.Config{}
+		}
+		base.TLSClientConfig.ServerName = ber.TLSServerName
+	}
+
+	var transport http.RoundTripper = base
+	switch ber.BackendProtocol {
+	case BackendProtocolHTTP1:
+		setProtocols(base, true, false)
+	case BackendProtocolHTTP2:
+		setProtocols(base, false, true)
+	default:
+		http1 := base.Clone()
+		setProtocols(http1, true, false)
+		transport = &protocolMatchingTransport{http1: http1, http2: base}
+	}
+
+	if ber.DNSRefreshInterval > 0 {
+		go dialer.refresh(ber.DNSRefreshInterval, transport.(idleCloser), ber.done)
+	}
+
+	return transport
+}
+
+func (ber *BackendRouter) dialTimeout() time.Duration {
+	if ber.DialTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return ber.DialTimeout
+}
+
+// setProtocols restricts the transport to HTTP/1.1 or HTTP/2 (both over TLS and h2c).
+func setProtocols(transport *http.Transport, http1 bool, http2 bool) {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(http1)
+	protocols.SetHTTP2(http2)
+	protocols.SetUnencryptedHTTP2(http2)
+	transport.Protocols = protocols
+	transport.ForceAttemptHTTP2 = http2
+
+	// the clone of the default transport can carry over h2 in its ALPN list, which would let a
+	// TLS backend pick HTTP/2 on a transport that's only going to speak HTTP/1.1.
+	if !http2 {
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		if transport.TLSClientConfig != nil {
+			transport.TLSClientConfig.NextProtos = nil
+		}
+	}
+}
+
+// protocolMatchingTransport sends requests that arrived over HTTP/2 through a transport that
+// negotiates HTTP/2 with the backend (when it's TLS and supports it) and everything else over HTTP/1.1.
+type protocolMatchingTransport struct {
+	http1 *http.Transport
+	http2 *http.Transport
+}
+
+func (t *protocolMatchingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.ProtoMajor >= 2 {
+		return t.http2.RoundTrip(req)
+	}
+	return t.http1.RoundTrip(req)
+}
+
+func (t *protocolMatchingTransport) CloseIdleConnections() {
+	t.http1.CloseIdleConnections()
+	t.http2.CloseIdleConnections()
+}
+
 func dialTLS(network, addr string) (net.Conn, error) {
 	conn, err := net.Dial(network, addr)
 	if err != nil {
@@ -30,7 +144,7 @@ func dialTLS(network, addr string) (net.Conn, error) {
 
 	// Verify here
 	cert.VerifyHostname(host)
-	log.Infof(fmt.Sprintf("%v",cert.Subject))
+	log.Infof("%v", cert.Subject)
 
 	return tlsConn, nil
 }
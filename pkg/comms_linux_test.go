@@ -0,0 +1,59 @@
+package pkg
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fullBacklogAddr returns the address of a socket listening with a backlog of 0 and one connection
+// already queued, so further connects hang (the SYNs are dropped) rather than being refused.
+func fullBacklogAddr(t *testing.T) string {
+	t.Helper()
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Close(fd) })
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", sa.(*syscall.SockaddrInet4).Port)
+
+	queued, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { queued.Close() })
+	return addr
+}
+
+func TestDialTimeoutGivesFastBadGateway(t *testing.T) {
+	l := NewLBLight(0)
+	ber := NewBackendRouter("127.0.0.1", 9000, nil, map[string]bool{"/": true}, 1)
+	ber.AllowLazyCreation = false
+	ber.DialTimeout = 200 * time.Millisecond
+	ber.AddBackend("http://"+fullBacklogAddr(t), nil)
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	start := time.Now()
+	resp, _ := get(t, lb.URL+"/")
+	elapsed := time.Since(start)
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected 502 for an unreachable backend, got %d", resp.StatusCode)
+	}
+	if elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected the 502 at about the 200ms DialTimeout, took %s", elapsed)
+	}
+}
@@ -0,0 +1,154 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExpectContinueForwardsBody(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Expect") != "100-continue" {
+			t.Errorf("backend didn't get Expect: 100-continue, got %q", r.Header.Get("Expect"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.ExpectContinueTimeout = 5 * time.Second
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	payload := strings.Repeat("upload ", 1000)
+	got100 := false
+	trace := &httptrace.ClientTrace{Got100Continue: func() { got100 = true }}
+	req, _ := http.NewRequest(http.MethodPost, lb.URL+"/upload", strings.NewReader(payload))
+	req.Header.Set("Expect", "100-continue")
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	// the client only holds the body back for the 100 when it has an ExpectContinueTimeout.
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 5 * time.Second}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if !got100 {
+		t.Error("client never got 100 Continue")
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != payload {
+		t.Errorf("expected the body echoed back with a 200, got %d with %d bytes", resp.StatusCode, len(body))
+	}
+}
+
+func TestDisableKeepAlives(t *testing.T) {
+	for _, tt := range []struct {
+		disable bool
+		conns   int32
+	}{
+		{false, 1},
+		{true, 5},
+	} {
+		var conns int32
+		backend := httptest.NewUnstartedServer(textHandler("ok"))
+		backend.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&conns, 1)
+			}
+		}
+		backend.Start()
+
+		l := NewLBLight(0)
+		ber := routerFor(t, backend, "/")
+		ber.DisableKeepAlives = tt.disable
+		addRouter(t, l, ber)
+		lb := serveLB(t, l)
+
+		for i := 0; i < 5; i++ {
+			get(t, lb.URL+"/")
+		}
+		if got := atomic.LoadInt32(&conns); got != tt.conns {
+			t.Errorf("DisableKeepAlives %v: expected %d backend connections for 5 requests, got %d", tt.disable, tt.conns, got)
+		}
+		backend.Close()
+	}
+}
+
+func TestTLSServerName(t *testing.T) {
+	serverNames := make(chan string, 10)
+	backend := httptest.NewUnstartedServer(textHandler("ok"))
+	backend.TLS = &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		serverNames <- hello.ServerName
+		return nil, nil
+	}}
+	backend.StartTLS()
+	t.Cleanup(backend.Close)
+
+	l := NewLBLight(0)
+	ber := NewBackendRouter("127.0.0.1", 9000, nil, map[string]bool{"/": true}, 1)
+	ber.AllowLazyCreation = false
+	ber.TLSServerName = "api.internal"
+	ber.AddBackend(backend.URL, nil)
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	// the test certificate isn't trusted (or for api.internal), so the handshake fails after the hello.
+	get(t, lb.URL+"/")
+	select {
+	case name := <-serverNames:
+		if name != "api.internal" {
+			t.Errorf("expected the backend to see SNI api.internal, got %q", name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("backend never got a TLS handshake")
+	}
+}
+
+// recordingTransport records the path of every request sent through it.
+type recordingTransport struct {
+	mux   sync.Mutex
+	paths []string
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.mux.Lock()
+	rt.paths = append(rt.paths, req.URL.Path)
+	rt.mux.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestCustomTransport(t *testing.T) {
+	backend := newBackendServer(t, textHandler("ok"))
+
+	l := NewLBLight(0)
+	transport := &recordingTransport{}
+	ber := routerFor(t, backend, "/")
+	ber.Transport = transport
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	for _, path := range []string{"/a", "/b"} {
+		if resp, body := get(t, lb.URL+path); resp.StatusCode != http.StatusOK || body != "ok" {
+			t.Fatalf("%s: expected 200 ok, got %d %q", path, resp.StatusCode, body)
+		}
+	}
+
+	transport.mux.Lock()
+	defer transport.mux.Unlock()
+	if !reflect.DeepEqual(transport.paths, []string{"/a", "/b"}) {
+		t.Errorf("expected both requests through the custom transport, got %v", transport.paths)
+	}
+}
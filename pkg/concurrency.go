@@ -0,0 +1,106 @@
+package pkg
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrConcurrencyQueueTimeout is returned when a request waits ConcurrencyQueueTimeout for a slot in
+// a router that's at MaxConcurrentRequests.
+var ErrConcurrencyQueueTimeout = errors.New("timed out waiting for router concurrency slot")
+
+// fairSemaphore limits concurrent holders, handing slots out to waiters in FIFO order.
+type fairSemaphore struct {
+	max int
+
+	mux     sync.Mutex
+	active  int
+	waiters *list.List
+}
+
+func newFairSemaphore(max int) *fairSemaphore {
+	return &fairSemaphore{max: max, waiters: list.New()}
+}
+
+// acquire takes a slot, queueing behind earlier requests until one is free, ctx is done or
+// timeout (if > 0) passes.
+func (fs *fairSemaphore) acquire(ctx context.Context, timeout time.Duration) error {
+	fs.mux.Lock()
+	if fs.active < fs.max && fs.waiters.Len() == 0 {
+		fs.active++
+		fs.mux.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	el := fs.waiters.PushBack(ready)
+	fs.mux.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-expired:
+		err = ErrConcurrencyQueueTimeout
+	}
+
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+	select {
+	case <-ready:
+		// handed a slot as we gave up, pass it on.
+		fs.releaseLocked()
+	default:
+		fs.waiters.Remove(el)
+	}
+	return err
+}
+
+// release gives the slot to the longest waiting request, if any.
+func (fs *fairSemaphore) release() {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+	fs.releaseLocked()
+}
+
+func (fs *fairSemaphore) releaseLocked() {
+	if front := fs.waiters.Front(); front != nil {
+		fs.waiters.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+	fs.active--
+}
+
+// acquireConcurrencySlot waits for one of the routers MaxConcurrentRequests slots, writing a 503 if
+// it can't get one. Returns the func to release the slot, or nil if the request has been dealt with.
+func (ber *BackendRouter) acquireConcurrencySlot(res http.ResponseWriter, req *http.Request) func() {
+	if ber.MaxConcurrentRequests <= 0 {
+		return func() {}
+	}
+	ber.concurrencyOnce.Do(func() {
+		ber.concurrency = newFairSemaphore(ber.MaxConcurrentRequests)
+	})
+
+	if err := ber.concurrency.acquire(req.Context(), ber.ConcurrencyQueueTimeout); err != nil {
+		log.Warnf("No concurrency slot in router %s for URL %s : %s", ber.String(), req.RequestURI, err.Error())
+		res.Header().Set("Retry-After", strconv.Itoa(ber.retryAfterSeconds()))
+		res.WriteHeader(http.StatusServiceUnavailable)
+		return nil
+	}
+	return ber.concurrency.release
+}
@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// queued returns how many requests are waiting for one of the routers concurrency slots.
+func queued(ber *BackendRouter) int {
+	ber.concurrencyOnce.Do(func() {
+		ber.concurrency = newFairSemaphore(ber.MaxConcurrentRequests)
+	})
+	ber.concurrency.mux.Lock()
+	defer ber.concurrency.mux.Unlock()
+	return ber.concurrency.waiters.Len()
+}
+
+func TestMaxConcurrentRequestsFIFO(t *testing.T) {
+	next := make(chan struct{})
+	var mux sync.Mutex
+	var order []string
+	slow := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		order = append(order, r.URL.Query().Get("id"))
+		mux.Unlock()
+		select {
+		case <-next:
+		case <-time.After(5 * time.Second):
+		}
+	})
+	other := newBackendServer(t, textHandler("other"))
+
+	l := NewLBLight(0)
+	capped := routerFor(t, slow, "/capped")
+	capped.MaxConcurrentRequests = 1
+	addRouter(t, l, capped)
+	addRouter(t, l, routerFor(t, other, "/other"))
+	lb := serveLB(t, l)
+
+	const requests = 5
+	statuses := make(chan int, requests)
+	for i := 0; i < requests; i++ {
+		go func(id int) {
+			resp, err := http.Get(lb.URL + "/capped?id=" + strconv.Itoa(id))
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}(i)
+		// one at a time so the arrival order is known.
+		if i == 0 {
+			waitFor(t, "the first request to reach the backend", func() bool {
+				mux.Lock()
+				defer mux.Unlock()
+				return len(order) == 1
+			})
+		} else {
+			waitFor(t, "the request to queue", func() bool { return queued(capped) == i })
+		}
+	}
+
+	if resp, body := get(t, lb.URL+"/other"); resp.StatusCode != http.StatusOK || body != "other" {
+		t.Errorf("expected the other router unaffected by the cap, got %d %q", resp.StatusCode, body)
+	}
+
+	for i := 0; i < requests; i++ {
+		next <- struct{}{}
+		if status := <-statuses; status != http.StatusOK {
+			t.Errorf("expected 200, got %d", status)
+		}
+	}
+	mux.Lock()
+	defer mux.Unlock()
+	if len(order) != requests {
+		t.Fatalf("expected %d requests at the backend, got %v", requests, order)
+	}
+	for i, id := range order {
+		if id != strconv.Itoa(i) {
+			t.Errorf("expected the queued requests served in arrival order, got %v", order)
+			break
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// ConflictPolicy determines what AddBackendRouter does when a route (path, header value, host or
+// body value) is already registered to another router.
+type ConflictPolicy int
+
+const (
+	// ConflictError fails the registration, leaving the existing routes alone. Default.
+	ConflictError ConflictPolicy = iota
+
+	// ConflictReplace gives the route to the new router, last write wins.
+	ConflictReplace
+
+	// ConflictMerge leaves the route with the existing router but adds the new routers backends
+	// (for its host/port, and copies of any it already has) to it. A new router with all its routes
+	// merged away isn't added at all, its own backends are stopped.
+	ConflictMerge
+)
+
+// handleConflict applies the ConflictPolicy for a route of ber that's already registered to existing.
+// Returns true if ber should take the route over, or err (the conflict) if the registration should fail.
+// merged tracks the routers already merged into during this registration. Caller must hold the lock.
+func (l *LBLight) handleConflict(existing *BackendRouter, ber *BackendRouter, conflict error, merged map[*BackendRouter]bool) (bool, error) {
+	switch l.ConflictPolicy {
+	case ConflictReplace:
+		log.Infof("%s, replacing router %s with %s", conflict.Error(), existing.String(), ber.String())
+		return true, nil
+	case ConflictMerge:
+		if !merged[existing] && existing != ber {
+			log.Infof("%s, merging backends of router %s into %s", conflict.Error(), ber.String(), existing.String())
+			existing.mergeBackends(ber)
+			merged[existing] = true
+		}
+		return false, nil
+	}
+	return false, conflict
+}
+
+// mergeBackends adds froms backends to the router. from usually isn't started so has no backends
+// yet, so one is added for its host/port (unless its backends come from a resolver) along with a
+// copy of each backend it does have.
+func (ber *BackendRouter) mergeBackends(from *BackendRouter) {
+	from.mux.Lock()
+	backends := append([]*Backend{}, from.backends...)
+	uri := ""
+	if from.Resolver == nil {
+		uri = from.backendURI()
+	}
+	from.mux.Unlock()
+
+	ber.mux.Lock()
+	defer ber.mux.Unlock()
+	for _, be := range backends {
+		if be.url.String() == uri {
+			uri = ""
+		}
+		copied := ber.newBackend(be.url.String())
+		copied.Metadata = be.Metadata
+		copied.Weight = be.Weight
+		ber.backends = append(ber.backends, copied)
+	}
+	if uri != "" {
+		ber.backends = append(ber.backends, ber.newBackend(uri))
+	}
+	ber.notifyReleased()
+}
+
+// stopAbsorbed stops a router all of whose routes were merged into others. Its backends were
+// copied over, so the originals (and their health checks) are dropped.
+func (ber *BackendRouter) stopAbsorbed() {
+	ber.mux.Lock()
+	for _, be := range ber.backends {
+		close(be.stopped)
+	}
+	ber.backends = nil
+	ber.mux.Unlock()
+
+	ber.Close()
+	log.Infof("Router %s merged away, not adding it", ber.String())
+}
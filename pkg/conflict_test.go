@@ -0,0 +1,103 @@
+package pkg
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConflictReplace(t *testing.T) {
+	a := newBackendServer(t, textHandler("a"))
+	b := newBackendServer(t, textHandler("b"))
+
+	l := NewLBLight(0)
+	l.ConflictPolicy = ConflictReplace
+	addRouter(t, l, routerFor(t, a, "/api"))
+	addRouter(t, l, routerFor(t, b, "/api"))
+	lb := serveLB(t, l)
+
+	if resp, body := get(t, lb.URL+"/api"); resp.StatusCode != http.StatusOK || body != "b" {
+		t.Errorf("expected the replacing router to get /api, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestConflictMerge(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	a := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Write([]byte("a"))
+	})
+	b := newBackendServer(t, textHandler("b"))
+
+	l := NewLBLight(0)
+	l.ConflictPolicy = ConflictMerge
+	existing := routerFor(t, a, "/api")
+	existing.AllowLazyCreation = false
+	existing.AddBackend(a.URL, nil)
+	addRouter(t, l, existing)
+	addRouter(t, l, routerFor(t, b, "/api"))
+	lb := serveLB(t, l)
+
+	urls := backendIDs(existing)
+	if len(urls) != 2 || urls[a.URL] == "" || urls[b.URL] == "" {
+		t.Fatalf("expected the merged router to have backends for a and b, got %v", urls)
+	}
+
+	// a is busy, so the merged in b gets the next request.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := http.Get(lb.URL + "/api")
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+	if resp, body := get(t, lb.URL+"/api"); resp.StatusCode != http.StatusOK || body != "b" {
+		t.Errorf("expected the merged backend b to take traffic, got %d %q", resp.StatusCode, body)
+	}
+	close(release)
+	<-done
+}
+
+func TestConflictMergeStopsAbsorbedRouter(t *testing.T) {
+	a := newBackendServer(t, textHandler("a"))
+	var probes int32
+	b := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			atomic.AddInt32(&probes, 1)
+		}
+		w.Write([]byte("b"))
+	})
+
+	l := NewLBLight(0)
+	l.ConflictPolicy = ConflictMerge
+	existing := routerFor(t, a, "/api")
+	addRouter(t, l, existing)
+
+	absorbed := routerFor(t, b, "/api")
+	absorbed.HealthCheck = &HealthCheckConfig{Path: "/healthz", Interval: 10 * time.Millisecond}
+	absorbed.AddBackend(b.URL, nil)
+	waitFor(t, "the absorbed routers backend to be probed", func() bool {
+		return atomic.LoadInt32(&probes) > 0
+	})
+	addRouter(t, l, absorbed)
+
+	if routers := l.Stats().Routers; len(routers) != 1 || routers[0].Router != existing.String() {
+		t.Errorf("expected only the existing router listed, got %+v", routers)
+	}
+	if backends := absorbed.stats().Backends; len(backends) != 0 {
+		t.Errorf("expected the absorbed router to keep no backends, got %+v", backends)
+	}
+
+	// a probe may have been in flight when it was stopped.
+	time.Sleep(50 * time.Millisecond)
+	before := atomic.LoadInt32(&probes)
+	time.Sleep(100 * time.Millisecond)
+	if after := atomic.LoadInt32(&probes); after != before {
+		t.Errorf("expected the absorbed routers health checks stopped, got %d more probes", after-before)
+	}
+}
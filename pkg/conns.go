@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"net"
+	"net/http"
+	"sync"
+)
+
+// ConnectionStats is a point in time view of the client connections to the traffic listener.
+type ConnectionStats struct {
+	// Accepted and Closed are totals since start. Hijacked connections (eg websockets) count as closed.
+	Accepted int64 `json:"accepted"`
+	Closed   int64 `json:"closed"`
+
+	// New connections haven't sent a request yet, Active ones are part way through a request and
+	// Idle ones are kept alive waiting for the next.
+	New    int64 `json:"new"`
+	Active int64 `json:"active"`
+	Idle   int64 `json:"idle"`
+}
+
+// connTracker keeps ConnectionStats up to date from the http.Server ConnState callback.
+type connTracker struct {
+	mux    sync.Mutex
+	states map[net.Conn]http.ConnState
+	stats  ConnectionStats
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{states: make(map[net.Conn]http.ConnState)}
+}
+
+// connState is the http.Server ConnState hook.
+func (ct *connTracker) connState(conn net.Conn, state http.ConnState) {
+	ct.mux.Lock()
+	defer ct.mux.Unlock()
+
+	if previous, ok := ct.states[conn]; ok {
+		ct.adjust(previous, -1)
+	}
+
+	switch state {
+	case http.StateNew:
+		ct.stats.Accepted++
+	case http.StateClosed, http.StateHijacked:
+		ct.stats.Closed++
+		delete(ct.states, conn)
+		return
+	}
+	ct.states[conn] = state
+	ct.adjust(state, 1)
+}
+
+// adjust moves the gauge for state by delta. Caller must hold the lock.
+func (ct *connTracker) adjust(state http.ConnState, delta int64) {
+	switch state {
+	case http.StateNew:
+		ct.stats.New += delta
+	case http.StateActive:
+		ct.stats.Active += delta
+	case http.StateIdle:
+		ct.stats.Idle += delta
+	}
+}
+
+func (ct *connTracker) snapshot() ConnectionStats {
+	ct.mux.Lock()
+	defer ct.mux.Unlock()
+	return ct.stats
+}
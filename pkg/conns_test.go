@@ -0,0 +1,36 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestConnectionStats(t *testing.T) {
+	backend := newBackendServer(t, textHandler("ok"))
+
+	l := NewLBLight(0)
+	addRouter(t, l, routerFor(t, backend, "/"))
+	addr := serveLBServer(t, l)
+
+	transport := &http.Transport{}
+	client := &http.Client{Transport: transport}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get("http://" + addr + "/")
+		if err != nil {
+			t.Fatalf("request failed: %s", err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	// the three requests share one kept alive connection.
+	waitFor(t, "an idle connection", func() bool {
+		return l.Stats().Connections == ConnectionStats{Accepted: 1, Idle: 1}
+	})
+
+	transport.CloseIdleConnections()
+	waitFor(t, "the connection to close", func() bool {
+		return l.Stats().Connections == ConnectionStats{Accepted: 1, Closed: 1}
+	})
+}
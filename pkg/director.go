@@ -0,0 +1,103 @@
+package pkg
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// director wraps the backends ReverseProxy Director (which points the request at the backend)
+// with the routers request modifying options.
+func (ber *BackendRouter) director(next func(*http.Request)) func(*http.Request) {
+	return func(req *http.Request) {
+		ber.stripPrefix(req)
+		next(req)
+
+		if ber.ForwardHeaderAllowList != nil {
+			ber.filterHeaders(req)
+		}
+		stripHeaders(req.Header, ber.StripRequestHops)
+
+		if ber.UserAgent != "" {
+			req.Header.Set("User-Agent", ber.UserAgent)
+		}
+
+		if ber.CompressRequestBody {
+			gzipRequestBody(req)
+		}
+	}
+}
+
+// stripPrefix applies the StripPrefixes rule for the longest accepted prefix matching the request.
+// Matching is case insensitive (unless CaseSensitivePaths), same as the path routing.
+func (ber *BackendRouter) stripPrefix(req *http.Request) {
+	match := ""
+	for prefix := range ber.StripPrefixes {
+		if len(prefix) > len(match) && ber.hasPathPrefix(req.URL.Path, prefix) {
+			match = prefix
+		}
+	}
+	if match == "" {
+		return
+	}
+
+	strip := ber.StripPrefixes[match]
+	if !ber.hasPathPrefix(req.URL.Path, strip) {
+		return
+	}
+	path := req.URL.Path[len(strip):]
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	req.URL.Path = path
+	req.URL.RawPath = ""
+}
+
+// filterHeaders drops every request header not in ForwardHeaderAllowList. X-LB-Hops is always
+// kept so loop detection (LBLight.MaxHops) still works.
+func (ber *BackendRouter) filterHeaders(req *http.Request) {
+	allowed := map[string]bool{http.CanonicalHeaderKey(hopsHeader): true}
+	for _, header := range ber.ForwardHeaderAllowList {
+		allowed[http.CanonicalHeaderKey(header)] = true
+	}
+	for header := range req.Header {
+		if !allowed[http.CanonicalHeaderKey(header)] {
+			req.Header.Del(header)
+		}
+	}
+}
+
+// stripHeaders removes the named headers.
+func stripHeaders(header http.Header, names []string) {
+	for _, name := range names {
+		header.Del(name)
+	}
+}
+
+// gzipRequestBody replaces the request body with a gzipped version, compressed as it's streamed
+// to the backend. Bodies that are already encoded are left alone.
+func gzipRequestBody(req *http.Request) {
+	if req.Body == nil || req.Body == http.NoBody || req.Header.Get("Content-Encoding") != "" {
+		return
+	}
+
+	body := req.Body
+	pr, pw := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(gz, body)
+		if err == nil {
+			err = gz.Close()
+		}
+		body.Close()
+		pw.CloseWithError(err)
+	}()
+
+	// compressed length isn't known up front, so send it chunked.
+	req.Body = pr
+	req.GetBody = nil
+	req.ContentLength = -1
+	req.Header.Del("Content-Length")
+	req.Header.Set("Content-Encoding", "gzip")
+}
@@ -0,0 +1,179 @@
+package pkg
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCompressRequestBody(t *testing.T) {
+	payload := strings.Repeat("compress me ", 5000)
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("expected Content-Encoding gzip, got %q", r.Header.Get("Content-Encoding"))
+			return
+		}
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("body isn't gzipped: %s", err)
+			return
+		}
+		body, err := ioutil.ReadAll(gz)
+		if err != nil {
+			t.Errorf("unable to gunzip body: %s", err)
+			return
+		}
+		if string(body) != payload {
+			t.Errorf("gunzipped body differs, got %d bytes expected %d", len(body), len(payload))
+		}
+		w.Write([]byte("ok"))
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.CompressRequestBody = true
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	req, _ := http.NewRequest(http.MethodPost, lb.URL+"/upload", strings.NewReader(payload))
+	if resp, body := doRequest(t, req); resp.StatusCode != http.StatusOK || body != "ok" {
+		t.Errorf("expected 200 ok, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestPreserveHost(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	})
+
+	// the clients Host is forwarded either way, PreserveHost only makes it explicit.
+	for _, tt := range []struct {
+		preserve bool
+		expected string
+	}{
+		{false, "www.example.com"},
+		{true, "www.example.com"},
+	} {
+		l := NewLBLight(0)
+		ber := routerFor(t, backend, "/")
+		ber.PreserveHost = tt.preserve
+		addRouter(t, l, ber)
+		lb := serveLB(t, l)
+
+		req, _ := http.NewRequest(http.MethodGet, lb.URL+"/", nil)
+		req.Host = "www.example.com"
+		if resp, body := doRequest(t, req); resp.StatusCode != http.StatusOK || body != tt.expected {
+			t.Errorf("PreserveHost %v: expected the backend to see Host %q, got %d %q", tt.preserve, tt.expected, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestStripPrefixes(t *testing.T) {
+	backend := newBackendServer(t, pathHandler)
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/v1", "/legacy")
+	ber.StripPrefixes = map[string]string{"/v1": "/v1", "/legacy": "/legacy/api"}
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	for path, expected := range map[string]string{
+		"/v1/users":          "/users",
+		"/V1/users":          "/users",
+		"/v1":                "/",
+		"/legacy/api/orders": "/orders",
+		// doesn't match the strip for /legacy, sent as is.
+		"/legacy/other": "/legacy/other",
+	} {
+		if resp, body := get(t, lb.URL+path); resp.StatusCode != http.StatusOK || body != expected {
+			t.Errorf("%s: expected the backend to see %s, got %d %q", path, expected, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestForwardHeaderAllowList(t *testing.T) {
+	received := make(chan http.Header, 1)
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.ForwardHeaderAllowList = []string{"x-allowed", "Authorization"}
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	req, _ := http.NewRequest(http.MethodGet, lb.URL+"/", nil)
+	req.Header.Set("X-Allowed", "yes")
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("X-Secret", "internal")
+	req.Header.Set("Cookie", "session=1")
+	req.Header.Set("User-Agent", "test")
+	doRequest(t, req)
+
+	header := <-received
+	if header.Get("X-Allowed") != "yes" || header.Get("Authorization") != "Bearer token" {
+		t.Errorf("expected the allowed headers forwarded, got %v", header)
+	}
+	// the proxy and transport add these themselves.
+	added := map[string]bool{"X-Forwarded-For": true, "Accept-Encoding": true}
+	for name := range header {
+		if name != "X-Allowed" && name != "Authorization" && !added[name] {
+			t.Errorf("expected %s to be dropped, got %q", name, header.Get(name))
+		}
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.UserAgent()))
+	})
+
+	for _, tt := range []struct {
+		userAgent string
+		expected  string
+	}{
+		{"", "client/1.0"},
+		{"lblight/1.0", "lblight/1.0"},
+	} {
+		l := NewLBLight(0)
+		ber := routerFor(t, backend, "/")
+		ber.UserAgent = tt.userAgent
+		addRouter(t, l, ber)
+		lb := serveLB(t, l)
+
+		req, _ := http.NewRequest(http.MethodGet, lb.URL+"/", nil)
+		req.Header.Set("User-Agent", "client/1.0")
+		if _, body := doRequest(t, req); body != tt.expected {
+			t.Errorf("UserAgent %q: expected the backend to see %q, got %q", tt.userAgent, tt.expected, body)
+		}
+	}
+}
+
+func TestStripHops(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Internal-Trace", "backend-7")
+		w.Header().Set("X-Kept-Response", "yes")
+		w.Write([]byte(r.Header.Get("X-Internal-Auth") + "|" + r.Header.Get("X-Kept-Request")))
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.StripRequestHops = []string{"x-internal-auth"}
+	ber.StripResponseHops = []string{"X-Internal-Trace"}
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	req, _ := http.NewRequest(http.MethodGet, lb.URL+"/", nil)
+	req.Header.Set("X-Internal-Auth", "secret")
+	req.Header.Set("X-Kept-Request", "yes")
+	resp, body := doRequest(t, req)
+	if body != "|yes" {
+		t.Errorf("expected the backend to see only X-Kept-Request, got %q", body)
+	}
+	if resp.Header.Get("X-Internal-Trace") != "" || resp.Header.Get("X-Kept-Response") != "yes" {
+		t.Errorf("expected only X-Internal-Trace stripped from the response, got %v", resp.Header)
+	}
+}
@@ -0,0 +1,140 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostResolver looks up the addresses for a host name. *net.Resolver satisfies this,
+// but anything else (fake resolvers for tests, custom service lookups) can be plugged in.
+type HostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// resolvingDialer resolves the host on every new connection (instead of relying on whatever
+// the transport has cached) and remembers what each host resolved to, so a periodic refresh
+// can tell when DNS has changed under us.
+type resolvingDialer struct {
+	resolver HostResolver
+	dialer   *net.Dialer
+
+	mux sync.Mutex
+
+	// host -> sorted list of addresses last resolved.
+	resolved map[string][]string
+}
+
+func newResolvingDialer(resolver HostResolver, dialer *net.Dialer) *resolvingDialer {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	rd := resolvingDialer{}
+	rd.resolver = resolver
+	rd.dialer = dialer
+	rd.resolved = make(map[string][]string)
+	return &rd
+}
+
+// DialContext resolves the host part of addr and tries each address in turn.
+// IP literals are dialled directly.
+func (rd *resolvingDialer) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if net.ParseIP(host) != nil {
+		return rd.dialer.DialContext(ctx, network, addr)
+	}
+
+	addrs, err := rd.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, ip := range addrs {
+		conn, err := rd.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// lookup resolves host and records the result.
+func (rd *resolvingDialer) lookup(ctx context.Context, host string) ([]string, error) {
+	addrs, _, err := rd.lookupAndRecord(ctx, host)
+	return addrs, err
+}
+
+// lookupAndRecord resolves host and records the result. changed is true if the addresses
+// differ from the previous lookup of this host.
+func (rd *resolvingDialer) lookupAndRecord(ctx context.Context, host string) ([]string, bool, error) {
+	addrs, err := rd.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(addrs) == 0 {
+		return nil, false, fmt.Errorf("no addresses found for host %s", host)
+	}
+
+	sorted := append([]string{}, addrs...)
+	sort.Strings(sorted)
+
+	rd.mux.Lock()
+	defer rd.mux.Unlock()
+	previous, seen := rd.resolved[host]
+	changed := seen && strings.Join(previous, ",") != strings.Join(sorted, ",")
+	rd.resolved[host] = sorted
+	return addrs, changed, nil
+}
+
+// refresh re-resolves every host we've dialled every interval. If any of them have changed,
+// the idle connections in the transport are closed so the next request dials the new address.
+// Connections that are mid-request are left alone and will be replaced once they go idle.
+func (rd *resolvingDialer) refresh(interval time.Duration, transport idleCloser, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		rd.mux.Lock()
+		hosts := make([]string, 0, len(rd.resolved))
+		for host, _ := range rd.resolved {
+			hosts = append(hosts, host)
+		}
+		rd.mux.Unlock()
+
+		anyChanged := false
+		for _, host := range hosts {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			_, changed, err := rd.lookupAndRecord(ctx, host)
+			cancel()
+			if err != nil {
+				log.Warnf("Unable to re-resolve backend host %s : %s", host, err.Error())
+				continue
+			}
+			if changed {
+				log.Infof("Backend host %s resolves to new addresses", host)
+				anyChanged = true
+			}
+		}
+
+		if anyChanged {
+			transport.CloseIdleConnections()
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResolver resolves every host to addr, which can be changed as the test goes.
+type fakeResolver struct {
+	mux  sync.Mutex
+	addr string
+}
+
+func (fr *fakeResolver) set(addr string) {
+	fr.mux.Lock()
+	defer fr.mux.Unlock()
+	fr.addr = addr
+}
+
+func (fr *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	fr.mux.Lock()
+	defer fr.mux.Unlock()
+	return []string{fr.addr}, nil
+}
+
+func TestBackendFollowsDNSChange(t *testing.T) {
+	blue := newBackendServer(t, textHandler("blue"))
+	_, port := hostPort(t, blue)
+
+	// same port on another loopback address, so only the resolved IP differs.
+	ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.2", strconv.Itoa(port)))
+	if err != nil {
+		t.Skipf("unable to listen on 127.0.0.2 : %s", err)
+	}
+	green := httptest.NewUnstartedServer(textHandler("green"))
+	green.Listener.Close()
+	green.Listener = ln
+	green.Start()
+	defer green.Close()
+
+	resolver := &fakeResolver{addr: "127.0.0.1"}
+	l := NewLBLight(0)
+	ber := NewBackendRouter("backend.test", port, nil, map[string]bool{"/": true}, 1)
+	ber.DNSResolver = resolver
+	ber.DNSRefreshInterval = 20 * time.Millisecond
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	if _, body := get(t, lb.URL+"/"); body != "blue" {
+		t.Fatalf("expected blue before the DNS change, got %q", body)
+	}
+
+	resolver.set("127.0.0.2")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, body := get(t, lb.URL+"/")
+		if resp.StatusCode == http.StatusOK && body == "green" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still getting %q (%d) after the DNS change", body, resp.StatusCode)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,37 @@
+package pkg
+
+import (
+	log "github.com/sirupsen/logrus"
+	"net"
+	"os"
+)
+
+// Draining reports if the DrainFile exists. Checked on every call, so creating or removing the
+// file takes effect straight away.
+func (l *LBLight) Draining() bool {
+	if l.DrainFile == "" {
+		return false
+	}
+	_, err := os.Stat(l.DrainFile)
+	return err == nil
+}
+
+// drainListener closes new connections while the LB is draining.
+type drainListener struct {
+	net.Listener
+	lb *LBLight
+}
+
+func (dl *drainListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := dl.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if !dl.lb.Draining() {
+			return conn, nil
+		}
+		log.Debugf("Draining, refusing connection from %s", conn.RemoteAddr())
+		conn.Close()
+	}
+}
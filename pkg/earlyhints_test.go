@@ -0,0 +1,48 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"strconv"
+	"testing"
+)
+
+func TestEarlyHintsForwarded(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Write([]byte("page"))
+	})
+
+	for _, tt := range []struct {
+		name  string
+		setup func(l *LBLight)
+	}{
+		{"plain", func(l *LBLight) {}},
+		{"access log", func(l *LBLight) { l.AccessLog = accessLogTo(&lockedBuffer{}) }},
+		{"observer", func(l *LBLight) { l.RequestObserver = &recordingObserver{} }},
+	} {
+		l := NewLBLight(0)
+		tt.setup(l)
+		addRouter(t, l, routerFor(t, backend, "/"))
+		lb := serveLB(t, l)
+
+		var events []string
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				events = append(events, strconv.Itoa(code)+" "+header.Get("Link"))
+				return nil
+			},
+		}
+		req, _ := http.NewRequest(http.MethodGet, lb.URL+"/", nil)
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		resp, body := doRequest(t, req)
+		events = append(events, strconv.Itoa(resp.StatusCode)+" "+body)
+
+		expected := []string{"103 </style.css>; rel=preload; as=style", "200 page"}
+		if len(events) != len(expected) || events[0] != expected[0] || events[1] != expected[1] {
+			t.Errorf("%s: expected %q, got %q", tt.name, expected, events)
+		}
+	}
+}
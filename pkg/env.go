@@ -0,0 +1,163 @@
+package pkg
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	envPrefix      = "LBLIGHT_"
+	envRoutePrefix = "LBLIGHT_ROUTE_"
+)
+
+// LoadFromEnv creates an LBLight configured from environment variables:
+//
+//	LBLIGHT_PORT=8443                            port to listen on (default 8080)
+//	LBLIGHT_ROUTE_<NAME>=http://app:8080         backend for the route (http only), may include a base path
+//	LBLIGHT_ROUTE_<NAME>_PATHS=/api,/v1          path prefixes for the route (default /<name> lowercased)
+//	LBLIGHT_ROUTE_<NAME>_HEADERS=X-Env=prod,...  header matches for the route
+//	LBLIGHT_ROUTE_<NAME>_MAX_BACKENDS=10         max backends for the route (default 10)
+//
+// eg LBLIGHT_ROUTE_API=http://app:8080 sends everything under /api to app:8080.
+func LoadFromEnv() (*LBLight, error) {
+	return loadFromEnviron(os.Environ())
+}
+
+// envRoute collects the variables for a single LBLIGHT_ROUTE_<NAME>.
+type envRoute struct {
+	name        string
+	uri         string
+	paths       string
+	headers     string
+	maxBackends string
+}
+
+func loadFromEnviron(environ []string) (*LBLight, error) {
+	port := 8080
+	routes := make(map[string]*envRoute)
+
+	getRoute := func(name string) *envRoute {
+		route, ok := routes[name]
+		if !ok {
+			route = &envRoute{name: name}
+			routes[name] = route
+		}
+		return route
+	}
+
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], envPrefix) {
+			continue
+		}
+		key, val := parts[0], parts[1]
+
+		if key == envPrefix+"PORT" {
+			p, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %s : %s", key, val, err.Error())
+			}
+			port = p
+			continue
+		}
+
+		if !strings.HasPrefix(key, envRoutePrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, envRoutePrefix)
+		switch {
+		case strings.HasSuffix(name, "_PATHS"):
+			getRoute(strings.TrimSuffix(name, "_PATHS")).paths = val
+		case strings.HasSuffix(name, "_HEADERS"):
+			getRoute(strings.TrimSuffix(name, "_HEADERS")).headers = val
+		case strings.HasSuffix(name, "_MAX_BACKENDS"):
+			getRoute(strings.TrimSuffix(name, "_MAX_BACKENDS")).maxBackends = val
+		default:
+			getRoute(name).uri = val
+		}
+	}
+
+	// sorted so registration (and any conflict errors) are deterministic.
+	names := make([]string, 0, len(routes))
+	for name, _ := range routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lbl := NewLBLight(port)
+	for _, name := range names {
+		ber, err := routes[name].backendRouter()
+		if err != nil {
+			return nil, err
+		}
+		if err := lbl.AddBackendRouter(ber); err != nil {
+			return nil, err
+		}
+	}
+	return lbl, nil
+}
+
+// backendRouter converts the env route to a BackendRouter.
+func (er *envRoute) backendRouter() (*BackendRouter, error) {
+	if er.uri == "" {
+		return nil, fmt.Errorf("no backend set for route %s, expected %s%s", er.name, envRoutePrefix, er.name)
+	}
+
+	u, err := url.Parse(er.uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backend for route %s : %s", er.name, err.Error())
+	}
+	// backends are always proxied to over plain HTTP, so anything else would be silently downgraded.
+	if u.Scheme != "http" {
+		return nil, fmt.Errorf("backend for route %s must be http://, %s isn't supported : %s", er.name, u.Scheme, er.uri)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, fmt.Errorf("backend for route %s must include host and port : %s", er.name, er.uri)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return nil, fmt.Errorf("invalid port for route %s : %s", er.name, err.Error())
+	}
+
+	acceptedPaths := make(map[string]bool)
+	if er.paths == "" {
+		acceptedPaths["/"+strings.ToLower(er.name)] = true
+	}
+	for _, path := range strings.Split(er.paths, ",") {
+		path = strings.TrimSpace(path)
+		if path != "" {
+			acceptedPaths[path] = true
+		}
+	}
+
+	var acceptedHeaders map[string]string
+	for _, header := range strings.Split(er.headers, ",") {
+		if strings.TrimSpace(header) == "" {
+			continue
+		}
+		headerParts := strings.SplitN(header, "=", 2)
+		if len(headerParts) != 2 {
+			return nil, fmt.Errorf("invalid header for route %s, expected name=value : %s", er.name, header)
+		}
+		if acceptedHeaders == nil {
+			acceptedHeaders = make(map[string]string)
+		}
+		acceptedHeaders[strings.TrimSpace(headerParts[0])] = strings.TrimSpace(headerParts[1])
+	}
+
+	maxBackends := 10
+	if er.maxBackends != "" {
+		maxBackends, err = strconv.Atoi(er.maxBackends)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max backends for route %s : %s", er.name, err.Error())
+		}
+	}
+
+	ber := NewBackendRouter(u.Hostname(), port, acceptedHeaders, acceptedPaths, maxBackends)
+	ber.BasePath = u.Path
+	return ber, nil
+}
@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLoadFromEnvironRoutes(t *testing.T) {
+	api := newBackendServer(t, pathHandler)
+	web := newBackendServer(t, textHandler("web"))
+
+	l, err := loadFromEnviron([]string{
+		"HOME=/root",
+		"LBLIGHT_PORT=8443",
+		"LBLIGHT_ROUTE_API=" + api.URL + "/base",
+		"LBLIGHT_ROUTE_WEB=" + web.URL,
+		"LBLIGHT_ROUTE_WEB_PATHS=/static, /assets",
+		"LBLIGHT_ROUTE_WEB_HEADERS=X-Site=web",
+		"LBLIGHT_ROUTE_WEB_MAX_BACKENDS=2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.port != 8443 {
+		t.Errorf("expected port 8443, got %d", l.port)
+	}
+	lb := serveLB(t, l)
+
+	// the route name is the default path, and the base path comes from the URL.
+	if _, body := get(t, lb.URL+"/api/users"); body != "/base/api/users" {
+		t.Errorf("/api/users got %q from the backend, expected /base/api/users", body)
+	}
+	for _, path := range []string{"/static/app.js", "/assets/logo.png"} {
+		if _, body := get(t, lb.URL+path); body != "web" {
+			t.Errorf("%s got %q, expected web", path, body)
+		}
+	}
+	req, _ := http.NewRequest(http.MethodGet, lb.URL+"/other", nil)
+	req.Header.Set("X-Site", "web")
+	if _, body := doRequest(t, req); body != "web" {
+		t.Errorf("X-Site: web got %q, expected web", body)
+	}
+	if resp, _ := get(t, lb.URL+"/web"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("route with _PATHS set still got its default path, status %d", resp.StatusCode)
+	}
+
+	router, _ := l.GetBackendRouterByPathPrefix("/static")
+	if router == nil || router.maxBackends != 2 {
+		t.Errorf("expected the web router with 2 max backends, got %v", router)
+	}
+}
+
+func TestLoadFromEnvironErrors(t *testing.T) {
+	for _, environ := range [][]string{
+		{"LBLIGHT_PORT=http"},
+		{"LBLIGHT_ROUTE_API_PATHS=/api"},
+		{"LBLIGHT_ROUTE_API=http://app"},
+		{"LBLIGHT_ROUTE_API=http://app:8080", "LBLIGHT_ROUTE_API_HEADERS=X-Env"},
+		{"LBLIGHT_ROUTE_API=http://app:8080", "LBLIGHT_ROUTE_API_MAX_BACKENDS=lots"},
+	} {
+		if _, err := loadFromEnviron(environ); err == nil {
+			t.Errorf("expected an error for %v", environ)
+		}
+	}
+}
+
+func TestLoadFromEnvironRejectsNonHTTPBackends(t *testing.T) {
+	for _, uri := range []string{"https://app:443", "ws://app:8080"} {
+		_, err := loadFromEnviron([]string{"LBLIGHT_ROUTE_API=" + uri})
+		if err == nil || !strings.Contains(err.Error(), "must be http://") {
+			t.Errorf("%s: expected the scheme to be rejected, got %v", uri, err)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+type fallbackKey struct{}
+
+// fallbackFor returns the router to try when ber can't provide a backend for the request because
+// none are healthy, and the request to send it. Busy routers (pool exhausted, queue full) don't fall
+// back, the client gets told to retry instead, and nor do requests the client has given up on. Each
+// router is only tried once per request so fallback loops end.
+func (ber *BackendRouter) fallbackFor(req *http.Request, err error) (*BackendRouter, *http.Request) {
+	if ber.FallbackRouter == nil || req.Context().Err() != nil || busyError(err) || errors.Is(err, ErrUnknownForcedBackend) {
+		return nil, req
+	}
+
+	tried, _ := req.Context().Value(fallbackKey{}).(map[*BackendRouter]bool)
+	if tried[ber.FallbackRouter] || ber.FallbackRouter == ber {
+		return nil, req
+	}
+
+	updated := map[*BackendRouter]bool{ber: true, ber.FallbackRouter: true}
+	for router := range tried {
+		updated[router] = true
+	}
+	return ber.FallbackRouter, req.WithContext(context.WithValue(req.Context(), fallbackKey{}, updated))
+}
@@ -0,0 +1,106 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFallbackRouterChecksApply(t *testing.T) {
+	unhealthy := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	spare := newBackendServer(t, textHandler("spare"))
+
+	l := NewLBLight(0)
+	primary := routerFor(t, unhealthy, "/")
+	primary.AllowLazyCreation = false
+	primary.HealthCheck = &HealthCheckConfig{Path: "/healthz"}
+	fallback := routerFor(t, spare, "/spare")
+	primary.FallbackRouter = fallback
+	addRouter(t, l, primary)
+	addRouter(t, l, fallback)
+	lb := serveLB(t, l)
+
+	be := primary.AddBackend(unhealthy.URL, nil)
+	<-be.firstProbeDone
+
+	if resp, body := get(t, lb.URL+"/"); resp.StatusCode != http.StatusOK || body != "spare" {
+		t.Fatalf("expected the fallback to serve the request, got %d %q", resp.StatusCode, body)
+	}
+
+	fallback.RequestFilter = func(req *http.Request) (bool, int, string) {
+		return false, http.StatusTeapot, "filtered"
+	}
+	if resp, body := get(t, lb.URL+"/"); resp.StatusCode != http.StatusTeapot || body != "filtered" {
+		t.Errorf("expected the fallbacks filter to reject, got %d %q", resp.StatusCode, body)
+	}
+
+	fallback.RequestFilter = nil
+	fallback.Pause()
+	if resp, _ := get(t, lb.URL+"/"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 from the paused fallback, got %d", resp.StatusCode)
+	}
+}
+
+func TestNoFallbackForCancelledClient(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+	spare := newBackendServer(t, textHandler("spare"))
+
+	l := NewLBLight(0)
+	observer := &recordingObserver{}
+	l.RequestObserver = observer
+	host, port := hostPort(t, backend)
+	primary := NewBackendRouter(host, port, nil, map[string]bool{"/": true}, 1)
+	primary.QueueTimeout = 5 * time.Second
+	fallback := routerFor(t, spare, "/spare")
+	primary.FallbackRouter = fallback
+	addRouter(t, l, primary)
+	addRouter(t, l, fallback)
+	lb := serveLB(t, l)
+	t.Cleanup(func() { close(release) })
+
+	go func() {
+		if resp, err := http.Get(lb.URL + "/slow"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req, _ := http.NewRequest(http.MethodGet, lb.URL+"/queued", nil)
+		if resp, err := http.DefaultClient.Do(req.WithContext(ctx)); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	waitFor(t, "the request to queue", func() bool {
+		return l.Stats().Routers[0].Queued == 1
+	})
+	cancel()
+	<-done
+
+	waitFor(t, "the cancelled request to end", func() bool {
+		names, _ := observer.snapshot()
+		for _, name := range names {
+			if name == "ended" {
+				return true
+			}
+		}
+		return false
+	})
+	_, events := observer.snapshot()
+	for _, ev := range events {
+		if ev.Path == "/queued" && ev.Router == fallback.String() {
+			t.Errorf("expected the cancelled request not to fall back, got %s on %s", ev.Backend, ev.Router)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package pkg
+
+import (
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// FaultInjection delays or fails a share of a routers requests before they're proxied, for
+// testing how clients cope. Probabilities are from 0 (never) to 1 (every request).
+type FaultInjection struct {
+	// Delay is added to DelayProbability of requests.
+	Delay            time.Duration
+	DelayProbability float64
+
+	// AbortStatus is returned, without proxying, for AbortProbability of requests. Defaults to 503.
+	AbortStatus      int
+	AbortProbability float64
+}
+
+func (fi *FaultInjection) abortStatus() int {
+	if fi.AbortStatus <= 0 {
+		return http.StatusServiceUnavailable
+	}
+	return fi.AbortStatus
+}
+
+// injectFault applies the routers FaultInjection to the request. Returns true if the request was
+// aborted (or the client gave up during the delay) and has been dealt with.
+func (ber *BackendRouter) injectFault(res http.ResponseWriter, req *http.Request) bool {
+	fi := ber.FaultInjection
+	if fi == nil {
+		return false
+	}
+
+	if fi.Delay > 0 && rand.Float64() < fi.DelayProbability {
+		log.Debugf("Injecting %s delay for URL %s", fi.Delay, req.RequestURI)
+		timer := time.NewTimer(fi.Delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			res.WriteHeader(http.StatusGatewayTimeout)
+			return true
+		}
+	}
+
+	if rand.Float64() < fi.AbortProbability {
+		log.Debugf("Injecting %d abort for URL %s", fi.abortStatus(), req.RequestURI)
+		res.WriteHeader(fi.abortStatus())
+		return true
+	}
+	return false
+}
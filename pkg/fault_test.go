@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFaultInjectionAbort(t *testing.T) {
+	var hits int32
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.FaultInjection = &FaultInjection{AbortStatus: http.StatusTeapot, AbortProbability: 1}
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	for i := 0; i < 20; i++ {
+		if resp, _ := get(t, lb.URL+"/"); resp.StatusCode != http.StatusTeapot {
+			t.Fatalf("request %d: expected every request aborted with 418, got %d", i+1, resp.StatusCode)
+		}
+	}
+	if n := atomic.LoadInt32(&hits); n != 0 {
+		t.Errorf("expected aborted requests not to be proxied, got %d backend hits", n)
+	}
+}
+
+func TestFaultInjectionDelay(t *testing.T) {
+	backend := newBackendServer(t, textHandler("ok"))
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.FaultInjection = &FaultInjection{Delay: 20 * time.Millisecond, DelayProbability: 0.5}
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	const requests = 100
+	delayed := 0
+	for i := 0; i < requests; i++ {
+		start := time.Now()
+		if resp, _ := get(t, lb.URL+"/"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected delayed requests still proxied, got %d", resp.StatusCode)
+		}
+		if time.Since(start) >= 20*time.Millisecond {
+			delayed++
+		}
+	}
+	// 50% of 100, allowing for chance.
+	if delayed < 30 || delayed > 70 {
+		t.Errorf("expected about half of %d requests delayed, got %d", requests, delayed)
+	}
+}
@@ -0,0 +1,31 @@
+package pkg
+
+import (
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+)
+
+// RequestFilter is custom admission logic run before a request is proxied, eg to block certain
+// user agents. Returning allow false rejects the request with status (403 Forbidden if 0) and body.
+type RequestFilter func(req *http.Request) (allow bool, status int, body string)
+
+// rejectByFilter runs the filter (if any) and writes the rejection if the request isn't allowed.
+// Returns true if the request was rejected.
+func rejectByFilter(filter RequestFilter, res http.ResponseWriter, req *http.Request) bool {
+	if filter == nil {
+		return false
+	}
+
+	allow, status, body := filter(req)
+	if allow {
+		return false
+	}
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+	log.Debugf("Request %s rejected by filter with status %d", req.RequestURI, status)
+	res.WriteHeader(status)
+	io.WriteString(res, body)
+	return true
+}
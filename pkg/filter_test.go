@@ -0,0 +1,40 @@
+package pkg
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRequestFilterRejects(t *testing.T) {
+	backend := newBackendServer(t, textHandler("ok"))
+
+	l := NewLBLight(0)
+	l.RequestFilter = func(req *http.Request) (bool, int, string) {
+		if strings.Contains(req.UserAgent(), "BadBot") {
+			return false, http.StatusTeapot, "no bots"
+		}
+		if strings.Contains(req.UserAgent(), "Scraper") {
+			return false, 0, ""
+		}
+		return true, 0, ""
+	}
+	addRouter(t, l, routerFor(t, backend, "/"))
+	lb := serveLB(t, l)
+
+	for _, tt := range []struct {
+		userAgent string
+		status    int
+		body      string
+	}{
+		{"BadBot/1.0", http.StatusTeapot, "no bots"},
+		{"Scraper/2.0", http.StatusForbidden, ""},
+		{"Mozilla/5.0", http.StatusOK, "ok"},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, lb.URL+"/", nil)
+		req.Header.Set("User-Agent", tt.userAgent)
+		if resp, body := doRequest(t, req); resp.StatusCode != tt.status || body != tt.body {
+			t.Errorf("User-Agent %s: expected %d %q, got %d %q", tt.userAgent, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// forceBackendHeader names the backend (by URL or ID) a request should be sent to, see AllowForceBackend.
+const forceBackendHeader = "X-LB-Force-Backend"
+
+// ErrUnknownForcedBackend is returned when X-LB-Force-Backend names a backend the router doesn't have.
+var ErrUnknownForcedBackend = errors.New("unable to provide backend for request, forced backend unknown")
+
+// forcedBackend returns the backend the request asks for with X-LB-Force-Backend, if the router
+// allows the request to force one.
+func (ber *BackendRouter) forcedBackend(req *http.Request) (string, bool) {
+	if req == nil || ber.AllowForceBackend == nil {
+		return "", false
+	}
+	forced := req.Header.Get(forceBackendHeader)
+	if forced == "" || !ber.AllowForceBackend(req) {
+		return "", false
+	}
+	return forced, true
+}
+
+// getForcedBackend takes a backend with the URL or ID forced, if one is available. Backends made on
+// demand share the routers URL, so every match is tried before giving up. Caller must hold the lock.
+func (ber *BackendRouter) getForcedBackend(forced string) (*Backend, error) {
+	forced = strings.TrimSuffix(forced, "/")
+	matched, busy := false, false
+	for _, be := range ber.backends {
+		if be.ID != forced && strings.TrimSuffix(be.url.String(), "/") != forced {
+			continue
+		}
+		if be.available() {
+			be.take()
+			return be, nil
+		}
+		matched = true
+		busy = busy || be.InUse
+	}
+
+	if !matched {
+		return nil, ErrUnknownForcedBackend
+	}
+	if busy {
+		return nil, ErrPoolExhausted
+	}
+	return nil, fmt.Errorf("unable to provide backend for request, forced backend %s not healthy", forced)
+}
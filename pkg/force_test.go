@@ -0,0 +1,81 @@
+package pkg
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestForceBackend(t *testing.T) {
+	a := newBackendServer(t, textHandler("a"))
+	b := newBackendServer(t, textHandler("b"))
+
+	l := NewLBLight(0)
+	ber := NewBackendRouter("127.0.0.1", 9000, nil, map[string]bool{"/": true}, 2)
+	ber.AllowLazyCreation = false
+	ber.AllowForceBackend = func(req *http.Request) bool {
+		return req.Header.Get("X-Trusted") == "yes"
+	}
+	ber.AddBackend(a.URL, nil)
+	ber.AddBackend(b.URL, nil)
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	for _, tt := range []struct {
+		name    string
+		forced  string
+		trusted bool
+		status  int
+		body    string
+	}{
+		{"by url", b.URL, true, http.StatusOK, "b"},
+		{"by id", backendIDs(ber)[b.URL], true, http.StatusOK, "b"},
+		// untrusted requests are placed as usual, FirstAvailable picks a.
+		{"untrusted", b.URL, false, http.StatusOK, "a"},
+		{"unknown", "http://10.0.0.1:80", true, http.StatusBadRequest, "unknown backend\n"},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, lb.URL+"/", nil)
+		req.Header.Set(forceBackendHeader, tt.forced)
+		if tt.trusted {
+			req.Header.Set("X-Trusted", "yes")
+		}
+		if resp, body := doRequest(t, req); resp.StatusCode != tt.status || body != tt.body {
+			t.Errorf("%s: expected %d %q, got %d %q", tt.name, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestForceBackendByURLWithSlotHeld(t *testing.T) {
+	held := make(chan struct{})
+	release := make(chan struct{})
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hold" {
+			close(held)
+			<-release
+		}
+		w.Write([]byte("ok"))
+	})
+
+	l := NewLBLight(0)
+	ber := NewBackendRouter("127.0.0.1", 9000, nil, map[string]bool{"/": true}, 2)
+	ber.AllowLazyCreation = false
+	ber.AllowForceBackend = func(req *http.Request) bool { return true }
+	// two slots for the one URL, as lazily created backends have.
+	ber.AddBackend(backend.URL, nil)
+	ber.AddBackend(backend.URL, nil)
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+	defer close(release)
+
+	go func() {
+		if resp, err := http.Get(lb.URL + "/hold"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-held
+
+	req, _ := http.NewRequest(http.MethodGet, lb.URL+"/", nil)
+	req.Header.Set(forceBackendHeader, backend.URL)
+	if resp, body := doRequest(t, req); resp.StatusCode != http.StatusOK || body != "ok" {
+		t.Errorf("expected the free slot for the forced URL, got %d %q", resp.StatusCode, body)
+	}
+}
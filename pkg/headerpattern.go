@@ -0,0 +1,60 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// headerPattern is a compiled BackendRouter HeaderPatterns entry.
+type headerPattern struct {
+	header string
+	re     *regexp.Regexp
+	router *BackendRouter
+}
+
+// compileHeaderPatterns compiles the routers HeaderPatterns, failing on the first invalid regex.
+func (ber *BackendRouter) compileHeaderPatterns() ([]headerPattern, error) {
+	patterns := []headerPattern{}
+	for header, pattern := range ber.HeaderPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid header pattern %s for %s : %s", pattern, header, err.Error())
+		}
+		patterns = append(patterns, headerPattern{header: http.CanonicalHeaderKey(header), re: re, router: ber})
+	}
+	return patterns, nil
+}
+
+// matchesHeaderPatterns checks the request matches all of the routers compiled header patterns.
+func (ber *BackendRouter) matchesHeaderPatterns(req *http.Request) bool {
+	for _, hp := range ber.headerPatterns {
+		if !hp.re.MatchString(req.Header.Get(hp.header)) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupHeaderPattern returns the first registered router with a pattern for the header that
+// matches the value. Caller must hold the lock.
+func (l *LBLight) lookupHeaderPattern(headerName string, headerValue string) *BackendRouter {
+	header := http.CanonicalHeaderKey(headerName)
+	for _, hp := range l.headerPatterns {
+		if hp.header == header && hp.re.MatchString(headerValue) {
+			return hp.router
+		}
+	}
+	return nil
+}
+
+// matchHeaderPatternRoute returns the first registered router with a pattern matching the
+// request. Caller must hold the lock.
+func (l *LBLight) matchHeaderPatternRoute(req *http.Request) *BackendRouter {
+	for _, hp := range l.headerPatterns {
+		if hp.re.MatchString(req.Header.Get(hp.header)) && hp.router.matches(req) {
+			return hp.router
+		}
+	}
+	return nil
+}
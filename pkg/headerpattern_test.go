@@ -0,0 +1,39 @@
+package pkg
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHeaderPatternUserAgent(t *testing.T) {
+	mobile := newBackendServer(t, textHandler("mobile"))
+
+	l := NewLBLight(0)
+	host, port := hostPort(t, mobile)
+	ber := NewBackendRouter(host, port, nil, nil, 10)
+	ber.HeaderPatterns = map[string]string{"user-agent": `(?i)(iphone|android)`}
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	for _, tt := range []struct {
+		userAgent string
+		status    int
+		body      string
+	}{
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0)", http.StatusOK, "mobile"},
+		{"Mozilla/5.0 (Linux; Android 14)", http.StatusOK, "mobile"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64)", http.StatusNotFound, ""},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, lb.URL+"/", nil)
+		req.Header.Set("User-Agent", tt.userAgent)
+		if resp, body := doRequest(t, req); resp.StatusCode != tt.status || body != tt.body {
+			t.Errorf("User-Agent %s: expected %d %q, got %d %q", tt.userAgent, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+
+	invalid := NewBackendRouter("127.0.0.1", 9001, nil, nil, 1)
+	invalid.HeaderPatterns = map[string]string{"User-Agent": "("}
+	if err := l.AddBackendRouter(invalid); err == nil {
+		t.Errorf("expected an invalid pattern to fail registration")
+	}
+}
@@ -0,0 +1,106 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// headerPrefix is a BackendRouter HeaderPrefixes entry.
+type headerPrefix struct {
+	header string
+	prefix string
+	router *BackendRouter
+}
+
+// checkHeaderPrefixes works out which of the routers HeaderPrefixes can be registered, applying
+// the ConflictPolicy to any already registered. Nothing is registered yet so an error needs no
+// rolling back. Caller must hold the lock.
+func (l *LBLight) checkHeaderPrefixes(ber *BackendRouter, merged map[*BackendRouter]bool) ([]headerPrefix, error) {
+	prefixes := []headerPrefix{}
+	for h, prefix := range ber.HeaderPrefixes {
+		header := http.CanonicalHeaderKey(h)
+		if existing := l.lookupRegisteredPrefix(header, prefix); existing != nil {
+			replace, err := l.handleConflict(existing.router, ber, fmt.Errorf("Conflict: Backend header prefix %s : %s already registered", header, prefix), merged)
+			if err != nil {
+				return nil, err
+			}
+			if !replace {
+				continue
+			}
+		}
+		prefixes = append(prefixes, headerPrefix{header: header, prefix: prefix, router: ber})
+	}
+	return prefixes, nil
+}
+
+// lookupRegisteredPrefix returns the entry registered for exactly this header and prefix, if any.
+// Caller must hold the lock.
+func (l *LBLight) lookupRegisteredPrefix(header string, prefix string) *headerPrefix {
+	for i := range l.headerPrefixes {
+		if l.headerPrefixes[i].header == header && l.headerPrefixes[i].prefix == prefix {
+			return &l.headerPrefixes[i]
+		}
+	}
+	return nil
+}
+
+// registerHeaderPrefixes adds the checked prefixes, replacing any entries they take over.
+// Caller must hold the lock.
+func (l *LBLight) registerHeaderPrefixes(prefixes []headerPrefix) {
+	for _, hp := range prefixes {
+		if existing := l.lookupRegisteredPrefix(hp.header, hp.prefix); existing != nil {
+			existing.router = hp.router
+			continue
+		}
+		l.headerPrefixes = append(l.headerPrefixes, hp)
+	}
+}
+
+// matchesHeaderPrefixes checks the request matches all of the routers HeaderPrefixes.
+func (ber *BackendRouter) matchesHeaderPrefixes(req *http.Request) bool {
+	for header, prefix := range ber.HeaderPrefixes {
+		if !strings.HasPrefix(req.Header.Get(header), prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupHeaderPrefix returns the router with the longest prefix of the value registered for the
+// header. Caller must hold the lock.
+func (l *LBLight) lookupHeaderPrefix(headerName string, headerValue string, req *http.Request) *BackendRouter {
+	header := http.CanonicalHeaderKey(headerName)
+	var best *headerPrefix
+	for i, hp := range l.headerPrefixes {
+		if hp.header != header || !strings.HasPrefix(headerValue, hp.prefix) {
+			continue
+		}
+		if req != nil && !hp.router.matches(req) {
+			continue
+		}
+		if best == nil || len(hp.prefix) > len(best.prefix) {
+			best = &l.headerPrefixes[i]
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.router
+}
+
+// matchHeaderPrefixRoute returns the router with the longest matching prefix for any of the
+// requests headers. Caller must hold the lock.
+func (l *LBLight) matchHeaderPrefixRoute(req *http.Request) *BackendRouter {
+	headers := make(map[string]bool)
+	for _, hp := range l.headerPrefixes {
+		if headers[hp.header] {
+			continue
+		}
+		headers[hp.header] = true
+		if router := l.lookupHeaderPrefix(hp.header, req.Header.Get(hp.header), req); router != nil {
+			return router
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package pkg
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestLongestHeaderPrefixWins(t *testing.T) {
+	admin := newBackendServer(t, textHandler("admin"))
+	users := newBackendServer(t, textHandler("users"))
+
+	l := NewLBLight(0)
+	// registered shortest first, so it's the length that decides.
+	host, port := hostPort(t, users)
+	usersRouter := NewBackendRouter(host, port, nil, nil, 10)
+	usersRouter.HeaderPrefixes = map[string]string{"Authorization": "Bearer "}
+	addRouter(t, l, usersRouter)
+	host, port = hostPort(t, admin)
+	adminRouter := NewBackendRouter(host, port, nil, nil, 10)
+	adminRouter.HeaderPrefixes = map[string]string{"authorization": "Bearer admin-"}
+	addRouter(t, l, adminRouter)
+	lb := serveLB(t, l)
+
+	for _, tt := range []struct {
+		auth   string
+		status int
+		body   string
+	}{
+		{"Bearer admin-123", http.StatusOK, "admin"},
+		{"Bearer user-456", http.StatusOK, "users"},
+		{"Basic dXNlcjpwYXNz", http.StatusNotFound, ""},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, lb.URL+"/", nil)
+		req.Header.Set("Authorization", tt.auth)
+		if resp, body := doRequest(t, req); resp.StatusCode != tt.status || body != tt.body {
+			t.Errorf("Authorization %s: expected %d %q, got %d %q", tt.auth, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package pkg
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// HealthCheckConfig configures active health checking of a routers backends. Each backend
+// is probed with a GET to Path every Interval, any 2xx/3xx response counts as a success.
+type HealthCheckConfig struct {
+	// Path to probe, eg "/healthz".
+	Path string
+
+	// Interval between probes. Defaults to 10 seconds.
+	Interval time.Duration
+
+	// HealthyInterval and UnhealthyInterval override Interval for backends that are Alive or dead
+	// respectively, eg probe dead backends more often so they get traffic back sooner.
+	HealthyInterval   time.Duration
+	UnhealthyInterval time.Duration
+
+	// Timeout for each probe. Defaults to 2 seconds.
+	Timeout time.Duration
+
+	// HealthyThreshold is how many consecutive successful probes are needed before a backend
+	// is marked Alive and gets traffic. Defaults to 1.
+	HealthyThreshold int
+
+	// UnhealthyThreshold is how many consecutive failed probes before an Alive backend is
+	// marked dead. Defaults to 1.
+	UnhealthyThreshold int
+}
+
+func (hc HealthCheckConfig) interval() time.Duration {
+	if hc.Interval <= 0 {
+		return 10 * time.Second
+	}
+	return hc.Interval
+}
+
+// intervalFor is the time until the next probe of a backend, based on whether it's currently alive.
+func (hc HealthCheckConfig) intervalFor(alive bool) time.Duration {
+	if alive && hc.HealthyInterval > 0 {
+		return hc.HealthyInterval
+	}
+	if !alive && hc.UnhealthyInterval > 0 {
+		return hc.UnhealthyInterval
+	}
+	return hc.interval()
+}
+
+func (hc HealthCheckConfig) timeout() time.Duration {
+	if hc.Timeout <= 0 {
+		return 2 * time.Second
+	}
+	return hc.Timeout
+}
+
+func (hc HealthCheckConfig) healthyThreshold() int {
+	if hc.HealthyThreshold <= 0 {
+		return 1
+	}
+	return hc.HealthyThreshold
+}
+
+func (hc HealthCheckConfig) unhealthyThreshold() int {
+	if hc.UnhealthyThreshold <= 0 {
+		return 1
+	}
+	return hc.UnhealthyThreshold
+}
+
+// runHealthChecks probes the backend straight away and then every interval until the router is
+// closed or the backend removed. The interval is picked after each probe so it follows the backends state.
+func (ber *BackendRouter) runHealthChecks(be *Backend) {
+	config := *ber.HealthCheck
+	timer := time.NewTimer(config.interval())
+	defer timer.Stop()
+
+	first := true
+	for {
+		start := time.Now()
+		healthy := ber.probe(be, config)
+		if healthy {
+			be.observeLatency(time.Since(start))
+		}
+		be.observeResult(healthy)
+		alive := ber.recordProbe(be, config, healthy)
+		if first {
+			close(be.firstProbeDone)
+			first = false
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(config.intervalFor(alive))
+
+		select {
+		case <-ber.done:
+			return
+		case <-be.stopped:
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// probe does a single health check request against the backend.
+func (ber *BackendRouter) probe(be *Backend, config HealthCheckConfig) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout())
+	defer cancel()
+
+	probeURL := *be.url
+	probeURL.Path = config.Path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL.String(), nil)
+	if err != nil {
+		log.Errorf("Unable to create health check request for %s : %s", be.ID, err.Error())
+		return false
+	}
+	if ber.UserAgent != "" {
+		req.Header.Set("User-Agent", ber.UserAgent)
+	}
+
+	resp, err := ber.transport.RoundTrip(req)
+	if err != nil {
+		log.Debugf("Health check for %s failed : %s", be.ID, err.Error())
+		return false
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
+
+// recordProbe updates the consecutive success/failure counts for the backend and flips
+// Alive once the appropriate threshold is reached. Returns whether the backend is now Alive.
+func (ber *BackendRouter) recordProbe(be *Backend, config HealthCheckConfig, healthy bool) bool {
+	ber.mux.Lock()
+	defer ber.mux.Unlock()
+
+	if healthy {
+		be.consecutiveProbeFailures = 0
+		be.consecutiveProbeSuccesses++
+		if !be.Alive && be.consecutiveProbeSuccesses >= config.healthyThreshold() {
+			log.Infof("Backend %s is now alive", be.ID)
+			be.Alive = true
+		}
+		return be.Alive
+	}
+
+	be.consecutiveProbeSuccesses = 0
+	be.consecutiveProbeFailures++
+	if be.Alive && be.consecutiveProbeFailures >= config.unhealthyThreshold() {
+		log.Warnf("Backend %s is now dead", be.ID)
+		be.Alive = false
+	}
+	return be.Alive
+}
@@ -0,0 +1,106 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRecordProbeThresholds(t *testing.T) {
+	ber := NewBackendRouter("127.0.0.1", 9000, nil, nil, 1)
+	be := NewBackend("http://127.0.0.1:9000")
+	config := HealthCheckConfig{HealthyThreshold: 3, UnhealthyThreshold: 2}
+
+	// a failure part way resets the run.
+	for i, healthy := range []bool{true, true, false, true, true} {
+		if ber.recordProbe(be, config, healthy) {
+			t.Fatalf("alive after probe %d, without 3 successes in a row", i)
+		}
+	}
+	if !ber.recordProbe(be, config, true) {
+		t.Fatal("not alive after 3 successes in a row")
+	}
+
+	if !ber.recordProbe(be, config, false) {
+		t.Error("dead after 1 failure, threshold is 2")
+	}
+	if ber.recordProbe(be, config, false) {
+		t.Error("still alive after 2 failures in a row")
+	}
+}
+
+func TestBackendGetsTrafficAfterHealthyThreshold(t *testing.T) {
+	var probes int32
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			atomic.AddInt32(&probes, 1)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.AllowLazyCreation = false
+	ber.HealthCheck = &HealthCheckConfig{Path: "/healthz", Interval: 200 * time.Millisecond, HealthyThreshold: 3}
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	be := ber.AddBackend(backend.URL, nil)
+	<-be.firstProbeDone
+	if resp, _ := get(t, lb.URL+"/"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 after 1 successful probe, got %d", resp.StatusCode)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, _ := get(t, lb.URL+"/")
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("backend never got traffic, last status %d", resp.StatusCode)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&probes); n < 3 {
+		t.Errorf("backend got traffic after %d probes, expected at least 3", n)
+	}
+}
+
+func TestUnhealthyIntervalProbesFaster(t *testing.T) {
+	var probes, healthy int32
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.AllowLazyCreation = false
+	ber.HealthCheck = &HealthCheckConfig{Path: "/healthz", Interval: time.Hour, UnhealthyInterval: 20 * time.Millisecond}
+	addRouter(t, l, ber)
+	t.Cleanup(func() { l.Shutdown(context.Background()) })
+	be := ber.AddBackend(backend.URL, nil)
+	<-be.firstProbeDone
+
+	time.Sleep(300 * time.Millisecond)
+	if n := atomic.LoadInt32(&probes); n < 5 {
+		t.Errorf("expected a dead backend probed every 20ms, got %d probes in 300ms", n)
+	}
+
+	// once alive it's back to the hourly Interval.
+	atomic.StoreInt32(&healthy, 1)
+	waitFor(t, "the backend to come alive", func() bool {
+		return ber.stats().Backends[0].Alive
+	})
+	alive := atomic.LoadInt32(&probes)
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt32(&probes) - alive; n > 0 {
+		t.Errorf("expected no probes at the healthy interval, got %d in 200ms", n)
+	}
+}
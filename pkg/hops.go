@@ -0,0 +1,30 @@
+package pkg
+
+import (
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+// hopsHeader counts how many times a request has passed through an LB with MaxHops set.
+const hopsHeader = "X-LB-Hops"
+
+// rejectLoop returns 508 Loop Detected if the request has already been through MaxHops LBs,
+// otherwise counts this pass in its X-LB-Hops header. Returns true if the request was rejected.
+func (l *LBLight) rejectLoop(res http.ResponseWriter, req *http.Request) bool {
+	if l.MaxHops <= 0 {
+		return false
+	}
+
+	hops, err := strconv.Atoi(req.Header.Get(hopsHeader))
+	if err != nil || hops < 0 {
+		hops = 0
+	}
+	if hops >= l.MaxHops {
+		log.Errorf("Rejecting URL %s after %d hops, check for a backend pointing back at the LB", req.RequestURI, hops)
+		res.WriteHeader(http.StatusLoopDetected)
+		return true
+	}
+	req.Header.Set(hopsHeader, strconv.Itoa(hops+1))
+	return false
+}
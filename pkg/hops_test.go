@@ -0,0 +1,19 @@
+package pkg
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMaxHopsStopsLoop(t *testing.T) {
+	l := NewLBLight(0)
+	l.MaxHops = 3
+	lb := serveLB(t, l)
+
+	// the router's backend is the LB itself.
+	addRouter(t, l, routerFor(t, lb, "/"))
+
+	if resp, _ := get(t, lb.URL+"/"); resp.StatusCode != http.StatusLoopDetected {
+		t.Errorf("expected 508 for a request looping through the LB, got %d", resp.StatusCode)
+	}
+}
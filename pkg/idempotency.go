@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idempotencyHeader is the client supplied key requests are deduplicated by.
+const idempotencyHeader = "Idempotency-Key"
+
+// idempotentEntry is the response for an Idempotency-Key, or the request still getting it.
+type idempotentEntry struct {
+	done     chan struct{}
+	response *StaticResponse
+	expires  time.Time
+}
+
+// idempotencyCache holds a routers responses by Idempotency-Key until IdempotencyTTL passes.
+type idempotencyCache struct {
+	mux     sync.Mutex
+	entries map[string]*idempotentEntry
+}
+
+func (ber *BackendRouter) idempotencyMaxEntries() int {
+	if ber.IdempotencyMaxEntries <= 0 {
+		return 10000
+	}
+	return ber.IdempotencyMaxEntries
+}
+
+func (ber *BackendRouter) idempotencyMaxBodyBytes() int64 {
+	if ber.IdempotencyMaxBodyBytes <= 0 {
+		return 1 << 20
+	}
+	return ber.IdempotencyMaxBodyBytes
+}
+
+// idempotencyKey is what a request is deduplicated by. Scoped to the host and the clients
+// credentials, so one client reusing a key can't get another clients response.
+func idempotencyKey(req *http.Request) string {
+	return strings.Join([]string{req.Method, req.Host, req.URL.Path, req.Header.Get("Authorization"),
+		req.Header.Get("Cookie"), req.Header.Get(idempotencyHeader)}, "\n")
+}
+
+// idempotencyCache returns the routers cache, creating it on first use.
+func (ber *BackendRouter) idempotencyCache() *idempotencyCache {
+	ber.idempotencyOnce.Do(func() {
+		ber.idempotency = &idempotencyCache{entries: make(map[string]*idempotentEntry)}
+	})
+	return ber.idempotency
+}
+
+// sweep drops expired entries. Caller must hold the lock.
+func (ic *idempotencyCache) sweep(now time.Time) {
+	for key, entry := range ic.entries {
+		if !entry.expires.IsZero() && now.After(entry.expires) {
+			delete(ic.entries, key)
+		}
+	}
+}
+
+// idempotent proxies a request with an Idempotency-Key, unless a request with the same key (see
+// idempotencyKey) has already been, in which case its response is replayed instead. Requests with
+// the key still in flight wait for it. 5xx responses aren't kept, so those can be retried, nor are
+// ones too big to hold on to.
+func (l *LBLight) idempotent(res http.ResponseWriter, req *http.Request, backendRouter *BackendRouter) {
+	cache := backendRouter.idempotencyCache()
+	key := idempotencyKey(req)
+	now := time.Now()
+
+	cache.mux.Lock()
+	entry, ok := cache.entries[key]
+	if ok && !entry.expires.IsZero() && now.After(entry.expires) {
+		delete(cache.entries, key)
+		ok = false
+	}
+	if ok {
+		cache.mux.Unlock()
+		select {
+		case <-entry.done:
+			log.Debugf("Replaying response for %s %s", idempotencyHeader, req.Header.Get(idempotencyHeader))
+			res.Header().Set("Idempotent-Replayed", "true")
+			entry.response.write(res)
+		case <-req.Context().Done():
+			log.Debugf("Client gave up waiting on %s %s", idempotencyHeader, req.Header.Get(idempotencyHeader))
+		}
+		return
+	}
+
+	if len(cache.entries) >= backendRouter.idempotencyMaxEntries() {
+		cache.sweep(now)
+	}
+	if len(cache.entries) >= backendRouter.idempotencyMaxEntries() {
+		cache.mux.Unlock()
+		log.Warnf("Idempotency cache for router %s full, proxying URL %s without it", backendRouter.String(), req.RequestURI)
+		l.proxy(res, req, backendRouter)
+		return
+	}
+	entry = &idempotentEntry{done: make(chan struct{})}
+	cache.entries[key] = entry
+	cache.mux.Unlock()
+
+	rec := &recordingResponseWriter{header: make(http.Header)}
+
+	// always let any waiters go, including when the proxy panics (backend died mid-body).
+	defer func() {
+		if entry.response == nil {
+			entry.response = &StaticResponse{StatusCode: http.StatusBadGateway}
+		}
+
+		cache.mux.Lock()
+		if entry.response.StatusCode >= 500 || int64(len(entry.response.Body)) > backendRouter.idempotencyMaxBodyBytes() {
+			delete(cache.entries, key)
+		} else {
+			entry.expires = time.Now().Add(backendRouter.IdempotencyTTL)
+		}
+		cache.mux.Unlock()
+		close(entry.done)
+	}()
+
+	l.proxy(rec, req, backendRouter)
+
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	entry.response = &StaticResponse{StatusCode: status, Header: rec.header, Body: rec.body.Bytes()}
+	entry.response.write(res)
+}
@@ -0,0 +1,63 @@
+package pkg
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	var hits int32
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		if r.URL.Path == "/big" {
+			w.Write([]byte(strings.Repeat("x", 100)))
+			return
+		}
+		w.Write([]byte("order-" + strconv.Itoa(int(n))))
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.IdempotencyTTL = time.Minute
+	ber.IdempotencyMaxBodyBytes = 50
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	post := func(path string, host string, auth string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodPost, lb.URL+path, strings.NewReader("pay"))
+		req.Header.Set(idempotencyHeader, "key-1")
+		req.Host = host
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		return doRequest(t, req)
+	}
+
+	_, first := post("/pay", "shop.example.com", "Bearer alice")
+	resp, second := post("/pay", "shop.example.com", "Bearer alice")
+	if n := atomic.LoadInt32(&hits); n != 1 || first != second || resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected the backend hit once and the response replayed, got %d hits, %q then %q", n, first, second)
+	}
+
+	// the same key from another client, or for another host, is a different request.
+	if _, body := post("/pay", "shop.example.com", "Bearer bob"); body == first {
+		t.Errorf("expected another clients request with the same key to reach the backend, got %q", body)
+	}
+	if _, body := post("/pay", "other.example.com", "Bearer alice"); body == first {
+		t.Errorf("expected another hosts request with the same key to reach the backend, got %q", body)
+	}
+	if n := atomic.LoadInt32(&hits); n != 3 {
+		t.Errorf("expected 3 backend hits, got %d", n)
+	}
+
+	// too big to keep.
+	post("/big", "shop.example.com", "Bearer alice")
+	resp, _ = post("/big", "shop.example.com", "Bearer alice")
+	if n := atomic.LoadInt32(&hits); n != 5 || resp.Header.Get("Idempotent-Replayed") != "" {
+		t.Errorf("expected a response over IdempotencyMaxBodyBytes not to be kept, got %d hits", n)
+	}
+}
@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// InflightRequest describes a request currently being proxied, for /debug/inflight.
+type InflightRequest struct {
+	ID      uint64    `json:"id"`
+	Method  string    `json:"method"`
+	Path    string    `json:"path"`
+	Router  string    `json:"router"`
+	Backend string    `json:"backend"`
+	Start   time.Time `json:"start"`
+
+	// Age is how long the request has been with the backend, filled in by Inflight.
+	Age        time.Duration `json:"-"`
+	AgeSeconds float64       `json:"ageSeconds"`
+}
+
+// inflightTracker keeps the requests currently with a backend, keyed by a per LB request ID, along
+// with the funcs to cancel them.
+type inflightTracker struct {
+	mux      sync.Mutex
+	nextID   uint64
+	requests map[uint64]*InflightRequest
+	cancels  map[uint64]func()
+}
+
+func newInflightTracker() *inflightTracker {
+	return &inflightTracker{requests: make(map[uint64]*InflightRequest), cancels: make(map[uint64]func())}
+}
+
+// add records a request as in flight, returning the func to call once it's done.
+func (it *inflightTracker) add(ir InflightRequest, cancel func()) func() {
+	it.mux.Lock()
+	defer it.mux.Unlock()
+
+	it.nextID++
+	ir.ID = it.nextID
+	it.requests[ir.ID] = &ir
+	it.cancels[ir.ID] = cancel
+	return func() {
+		it.mux.Lock()
+		defer it.mux.Unlock()
+		delete(it.requests, ir.ID)
+		delete(it.cancels, ir.ID)
+	}
+}
+
+// CancelInflight aborts the in flight request with the ID (see Inflight), the client gets a 503.
+// Returns false if there's no such request, eg it's already finished.
+func (l *LBLight) CancelInflight(id uint64) bool {
+	l.inflight.mux.Lock()
+	cancel, ok := l.inflight.cancels[id]
+	l.inflight.mux.Unlock()
+
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// Inflight returns the requests currently being proxied, oldest first.
+func (l *LBLight) Inflight() []InflightRequest {
+	l.inflight.mux.Lock()
+	now := time.Now()
+	requests := []InflightRequest{}
+	for _, ir := range l.inflight.requests {
+		r := *ir
+		r.Age = now.Sub(r.Start)
+		r.AgeSeconds = r.Age.Seconds()
+		requests = append(requests, r)
+	}
+	l.inflight.mux.Unlock()
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].ID < requests[j].ID
+	})
+	return requests
+}
@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// inflightFrom fetches /debug/inflight from the admin API.
+func inflightFrom(t *testing.T, admin *httptest.Server) []InflightRequest {
+	t.Helper()
+	resp, body := get(t, admin.URL+"/debug/inflight")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 from /debug/inflight, got %d", resp.StatusCode)
+	}
+	requests := []InflightRequest{}
+	if err := json.Unmarshal([]byte(body), &requests); err != nil {
+		t.Fatalf("unable to decode %q : %s", body, err)
+	}
+	return requests
+}
+
+func TestDebugInflight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	l := NewLBLight(0)
+	addRouter(t, l, routerFor(t, backend, "/"))
+	lb := serveLB(t, l)
+	admin := httptest.NewServer(l.AdminHandler())
+	defer admin.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := http.Get(lb.URL + "/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	first := inflightFrom(t, admin)
+	if len(first) != 1 || first[0].Method != http.MethodGet || first[0].Path != "/slow" || first[0].Backend == "" {
+		t.Fatalf("expected the one GET /slow in flight, got %+v", first)
+	}
+	time.Sleep(100 * time.Millisecond)
+	second := inflightFrom(t, admin)
+	if len(second) != 1 || second[0].AgeSeconds < first[0].AgeSeconds+0.1 {
+		t.Errorf("expected the age to grow by at least 100ms, went from %f to %+v", first[0].AgeSeconds, second)
+	}
+
+	close(release)
+	<-done
+	waitFor(t, "the request to leave /debug/inflight", func() bool {
+		return len(inflightFrom(t, admin)) == 0
+	})
+}
+
+func TestCancelInflight(t *testing.T) {
+	started := make(chan struct{})
+	aborted := make(chan struct{})
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+			close(aborted)
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	l := NewLBLight(0)
+	addRouter(t, l, routerFor(t, backend, "/"))
+	lb := serveLB(t, l)
+	admin := httptest.NewServer(l.AdminHandler())
+	defer admin.Close()
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(lb.URL + "/stuck")
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-started
+
+	if resp, err := http.Post(admin.URL+"/debug/inflight/999999/cancel", "", nil); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 cancelling an unknown request, got %v %v", resp, err)
+	}
+
+	requests := inflightFrom(t, admin)
+	if len(requests) != 1 {
+		t.Fatalf("expected the one request in flight, got %+v", requests)
+	}
+	resp, err := http.Post(admin.URL+"/debug/inflight/"+strconv.FormatUint(requests[0].ID, 10)+"/cancel", "", nil)
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204 from the cancel, got %v %v", resp, err)
+	}
+
+	select {
+	case <-aborted:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the call to the backend to be aborted")
+	}
+	select {
+	case s := <-status:
+		if s == http.StatusOK {
+			t.Errorf("expected the cancelled request not to succeed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("expected the client to get an answer once cancelled")
+	}
+}
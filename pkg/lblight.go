@@ -1,24 +1,72 @@
 package pkg
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"text/template"
+	"time"
 )
 
 // Backend has the ReverseProxy to the real backend server.
 type Backend struct {
+	// ID identifies the backend within the LB, set by the router when it creates the backend.
+	ID string
+
+	// Metadata is arbitrary key/values for the backend (eg zone=us-east) used by selection strategies.
+	Metadata map[string]string
+
+	// Weight is the backends share of traffic for weighted strategies, relative to the other backends.
+	// 0 (or less) counts as 1.
+	Weight int
+
 	url          *url.URL // do we really need this here?
 	Alive        bool
 	InUse        bool
 	mux          sync.RWMutex
 	ReverseProxy *httputil.ReverseProxy
+
+	// nil unless the router has circuit breaking configured.
+	breaker *circuitBreaker
+
+	// sizes of the response bodies proxied from the backend.
+	responseSizes *sizeHistogram
+
+	// closed when the backend is removed from its router.
+	stopped chan struct{}
+
+	// true if the router is health checking the backend, in which case it only gets traffic when Alive.
+	healthChecked bool
+
+	// true for a lazily created backend until its first request is done, see MaxConcurrentCreations.
+	warming bool
+
+	// closed once the first health check has completed.
+	firstProbeDone chan struct{}
+
+	// consecutive health check results, guarded by the routers lock.
+	consecutiveProbeSuccesses int
+	consecutiveProbeFailures  int
+
+	// moving averages behind HealthScore, guarded by mux.
+	latencyEMA   float64
+	latencySeen  bool
+	errorRateEMA float64
+	scoreLatency time.Duration
 }
 
+// NewBackend creates a backend proxying to uri. If uri has a path (eg http://host:9000/base) then
+// it's prepended to the path of every proxied request, so /x goes to /base/x.
 func NewBackend(uri string) *Backend {
 	be := Backend{}
 	var err error
@@ -34,6 +82,101 @@ func NewBackend(uri string) *Backend {
 	return &be
 }
 
+func (be *Backend) weight() float64 {
+	if be.Weight <= 0 {
+		return 1
+	}
+	return float64(be.Weight)
+}
+
+// available reports if the backend can take a request right now. Caller must hold the routers lock.
+func (be *Backend) available() bool {
+	if be.InUse || (be.healthChecked && !be.Alive) {
+		return false
+	}
+	return be.breaker == nil || be.breaker.allow()
+}
+
+// take marks the backend InUse for a request. Caller must hold the routers lock.
+func (be *Backend) take() {
+	be.InUse = true
+	if be.breaker != nil {
+		be.breaker.begin()
+	}
+}
+
+// handleProxyError is the ReverseProxy ErrorHandler. Same as the default (log and 502) but also
+// counts the failure against the breaker.
+// Requests that hit their deadline get a 504 instead, and don't count against the breaker since
+// the deadline may well have been set by the client. Hitting the DialTimeout is the backends
+// fault though, so is a 502 like any other failure.
+// If the request is going to be retried on another backend nothing is written, the error is just
+// recorded for the retry loop.
+func (be *Backend) handleProxyError(res http.ResponseWriter, req *http.Request, err error) {
+	// cancelled by hand (CancelInflight), not the backends fault and mustn't be retried.
+	current := attemptFromContext(req.Context())
+	if current != nil && current.wasCancelled() {
+		log.Warnf("Request to backend %s cancelled : %s", be.url.String(), err.Error())
+		res.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) && req.Context().Err() != nil {
+		log.Warnf("Request to backend %s timed out : %s", be.url.String(), err.Error())
+		res.WriteHeader(http.StatusGatewayTimeout)
+		return
+	}
+
+	if be.breaker != nil {
+		be.breaker.recordFailure()
+	}
+	be.observeResult(false)
+
+	if current != nil {
+		current.recordFailure(be, err)
+
+		// leave it to the caller if it's going to retry.
+		if current.retryable {
+			current.err = err
+			return
+		}
+	}
+
+	if malformedResponse(err) {
+		log.Errorf("Malformed response from backend %s for %s : %s", be.url.String(), req.URL.Path, err.Error())
+	} else {
+		log.Errorf("Proxy error for backend %s : %s", be.url.String(), err.Error())
+	}
+	if current == nil {
+		res.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	if len(current.failures) > 1 {
+		log.Errorf("Giving up on URL %s, %s", req.RequestURI, current.detail())
+	}
+	current.writeBadGateway(res)
+}
+
+// ErrPoolExhausted is returned by GetBackend when every backend is busy and no more can be created.
+var ErrPoolExhausted = errors.New("unable to provide backend for request, pool exhausted")
+
+// ErrNoBackendAvailable is returned by GetBackend when lazy creation is disabled and none of the
+// existing backends are free.
+var ErrNoBackendAvailable = errors.New("unable to provide backend for request, no backend available")
+
+// MatchMode determines how a BackendRouter's accepted paths and headers are combined
+// when deciding if a request should go to it.
+type MatchMode int
+
+const (
+	// MatchAny routes the request if ANY of the paths or headers match. Default.
+	MatchAny MatchMode = iota
+
+	// MatchAll only routes the request if one of the paths AND all of the headers match.
+	MatchAll
+)
+
 // BackendRouter points to the REAL server doing the work, ie what the LB is connecting to.
 // includes list of header values and/or url paths that will be accepted for this backend.
 type BackendRouter struct {
@@ -45,11 +188,306 @@ type BackendRouter struct {
 	// if the beginning of the request is in acceptedPaths, then use this backend.
 	acceptedPaths map[string]bool
 
+	// CaseSensitivePaths matches the routers paths (and StripPrefixes) case sensitively. By default
+	// paths match whatever their case. Where a case sensitive and an insensitive router both match,
+	// the case sensitive one wins. Must be set before registering the router.
+	CaseSensitivePaths bool
+
 	// if the header (key) in acceptedHeaders matches the value, then use this backend
 	acceptedHeaders map[string]string
 
+	// HeaderWeight lets several routers register the same header value, traffic for the value
+	// is split between them in proportion to their weights. 0 (default) means the router needs
+	// the header value to itself.
+	HeaderWeight int
+
+	// HeaderPatterns routes requests where the header (key) matches the regex (value), eg
+	// "User-Agent": "^curl/". Exact header matches are tried first, then patterns in registration
+	// order. Must be set before registering the router.
+	HeaderPatterns map[string]string
+	headerPatterns []headerPattern
+
+	// HeaderPrefixes routes requests where the header (key) starts with the prefix (value), eg
+	// "Authorization": "Bearer admin-". When several prefixes match, the longest wins. Tried after
+	// exact header matches and before HeaderPatterns. Must be set before registering the router.
+	HeaderPrefixes map[string]string
+
+	// AcceptedHosts routes requests for these hosts (Host header) to this backend. Wildcards
+	// like "*.example.com" match any subdomain. Must be set before registering the router.
+	AcceptedHosts []string
+
+	// AcceptedBodyValues routes requests whose body value (as extracted by the LBLight BodyRouter)
+	// is one of these to this backend. Must be set before registering the router.
+	AcceptedBodyValues []string
+
+	// AcceptedMethods limits the router to these methods, eg GET only. Requests routed here with
+	// any other method get a 405 with an Allow header listing these. Empty (default) allows all.
+	AcceptedMethods []string
+
+	// ForwardHeaderAllowList, if set, is the only request headers sent on to the backends, all others
+	// are dropped. X-Forwarded-For is still added by the proxy. Include Connection and Upgrade for websockets.
+	ForwardHeaderAllowList []string
+
+	// AllowForceBackend lets requests it returns true for (eg from a trusted IP) pick their backend
+	// with the X-LB-Force-Backend header, giving the backends URL or ID. The backend has to be one
+	// the router already has, unknown ones get a 400. nil (default) ignores the header. For testing.
+	AllowForceBackend func(req *http.Request) bool
+
+	// StripRequestHops and StripResponseHops are extra headers (eg internal ones) removed on top of the
+	// standard hop-by-hop headers ReverseProxy drops, from requests before they go to the backend and
+	// from backend responses before they go to the client. The LBs own headers (X-Served-By etc) are kept.
+	StripRequestHops  []string
+	StripResponseHops []string
+
+	// UserAgent replaces the clients User-Agent on every request to the backends (health checks
+	// included), eg to identify the LB. Empty passes the clients through.
+	UserAgent string
+
+	// LogFormat is a text/template for this routers access log lines (when the LBLight has an
+	// AccessLog), executed with an AccessLogEntry, eg "{{.Status}} {{.Path}} {{.Backend}} {{.Duration}}".
+	// Compiled when the router is registered. Empty logs the usual fields.
+	LogFormat   string
+	logTemplate *template.Template
+
 	// list of all backends that can be used with the config.
 	backends []*Backend
+
+	// BasePath is prepended to the path of every request sent to the backends, eg "/base" sends /x to /base/x.
+	BasePath string
+
+	// AllowLazyCreation lets GetBackend create backends on demand (up to maxBackends). Defaults to true,
+	// when false only backends added explicitly (AddBackend, WarmPool or a Resolver) are used and
+	// ErrNoBackendAvailable is returned when none are free.
+	AllowLazyCreation bool
+
+	// MinBackends is the floor on the pool size. That many backends are created when the router is
+	// registered, and replaced if any are removed (RemoveBackend, ReplaceBackends). Not used with a Resolver.
+	MinBackends int
+
+	// MaxConcurrentCreations bounds how many lazily created backends can be warming up (handling
+	// their first request) at once, so a spike into an empty router ramps up rather than creating
+	// maxBackends backends in one go. Requests over the bound are treated as the pool being busy
+	// (queued with QueueTimeout, otherwise 503). 0 (default) means no bound.
+	MaxConcurrentCreations int
+
+	// HealthScoreLatency is the latency at which a backends HealthScore is halved. Default 100ms.
+	HealthScoreLatency time.Duration
+
+	// StripPrefixes gives per path rewrite rules: requests matching the accepted path prefix (key)
+	// have the value stripped from the front before being sent on, eg {"/v1": "/v1"} sends /v1/x as /x.
+	// The longest matching key wins. Applied before BasePath.
+	StripPrefixes map[string]string
+
+	// BufferRequestBody reads the whole request body into memory before proxying so it can be
+	// replayed (needed to retry or mirror a request). Costs memory per request, so leave it off
+	// (the default) for routes with large uploads and the body is streamed straight to the backend.
+	BufferRequestBody bool
+
+	// PreserveHost forwards the clients Host header to the backends as is. That's also the
+	// default, the proxy never rewrites Host, so this just makes it explicit for backends relying on it.
+	PreserveHost bool
+
+	// CompressRequestBody gzips request bodies sent to the backends (setting Content-Encoding: gzip).
+	// Only enable if the backends can decode gzipped requests.
+	CompressRequestBody bool
+
+	// Strategy is how a backend is picked from the pool. Defaults to FirstAvailable.
+	Strategy SelectionStrategy
+
+	// ZoneHeader is the request header the ZoneAware strategy reads the clients zone from, and
+	// ZoneMetadataKey the backend Metadata key it's compared against (defaults to "zone").
+	ZoneHeader      string
+	ZoneMetadataKey string
+
+	// UseForwardedFor makes strategies that use the client IP (IPHash) take it from the
+	// X-Forwarded-For header instead of the connection. Only enable behind a trusted proxy.
+	UseForwardedFor bool
+
+	// MatchMode controls if paths and headers are OR'd (MatchAny) or AND'd (MatchAll).
+	MatchMode MatchMode
+
+	// DNSResolver is used to resolve the backend host on each new connection. nil means net.DefaultResolver.
+	DNSResolver HostResolver
+
+	// DNSRefreshInterval is how often the backend host is re-resolved. If the addresses change, idle
+	// connections are closed so traffic follows DNS. 0 disables re-resolution.
+	DNSRefreshInterval time.Duration
+
+	// RetryAfterSeconds is sent in the Retry-After header of the 503 returned when every backend is
+	// busy and the pool is at maxBackends. Defaults to 1.
+	RetryAfterSeconds int
+
+	// MaintenanceResponse is served when the router has no backends at all and can't create any
+	// (eg its resolver returns nothing). nil falls back to the usual no backend handling.
+	MaintenanceResponse *StaticResponse
+
+	// FallbackRouter gets the request when this router has no healthy backends, rather than
+	// returning a 503. Its own FallbackRouter is tried in turn if it can't serve the request either.
+	// The fallbacks own checks (methods, filter, rate limit, pause, concurrency cap) apply to it.
+	FallbackRouter *BackendRouter
+
+	// CoalesceRequests sends concurrent identical GETs (same host, URI and Accept headers, without
+	// Authorization, Cookie or Range headers) to the backend once, every client gets a copy of the
+	// response. Responses setting a cookie aren't shared, the waiting clients send their own request.
+	// Meant for cacheable responses, each one is held in memory while it's sent out.
+	CoalesceRequests bool
+	coalesced        *coalescer
+	coalescerOnce    sync.Once
+
+	// IdempotencyTTL keeps the response to a request with an Idempotency-Key header for this long,
+	// a repeat of the request (same key, method, host, path and Authorization/Cookie credentials) gets
+	// the kept response rather than going to the backend again. 5xx responses, and ones with bodies over
+	// IdempotencyMaxBodyBytes (default 1MB), aren't kept. IdempotencyMaxEntries caps how many responses
+	// are held, default 10000. 0 (default) TTL turns it off.
+	IdempotencyTTL          time.Duration
+	IdempotencyMaxEntries   int
+	IdempotencyMaxBodyBytes int64
+	idempotency             *idempotencyCache
+	idempotencyOnce         sync.Once
+
+	// FaultInjection delays or aborts some of the routers requests before they're proxied, for chaos
+	// testing. nil (default) injects nothing.
+	FaultInjection *FaultInjection
+
+	// HeaderBuckets optionally splits traffic for this router between variant routers based on
+	// the hash of a header. nil sends everything to this router.
+	HeaderBuckets *HeaderBuckets
+
+	// HealthCheck enables active health checking of the backends. Health checked backends only get
+	// traffic once they're Alive, so a backend created on demand won't serve the request that created it.
+	// nil disables health checking.
+	HealthCheck *HealthCheckConfig
+
+	// ReadyMinBackends and ReadyMinPercent are how many of the routers backends have to be healthy for
+	// it to count as ready (see LBLight.Ready), as a count (default 1) and a percentage of the pool
+	// (default 0). Both have to be met.
+	ReadyMinBackends int
+	ReadyMinPercent  int
+
+	// LargeResponseThreshold logs a warning for any response body from the backends bigger than
+	// this many bytes. 0 disables the warning.
+	LargeResponseThreshold int64
+
+	// RequestFilter is run on requests routed to this router before they're proxied.
+	// Runs after the LBLight filter, if any.
+	RequestFilter RequestFilter
+
+	// AddServedBy adds "X-Served-By: lblight/<backend ID>" to responses, to see which backend handled
+	// a request. Can also be turned on for every router with LBLight.AddServedBy.
+	AddServedBy bool
+
+	// AddResponseTime adds "X-Response-Time: <ms>" to responses, the time from the LB receiving the
+	// request to the backend starting its response. Can also be turned on for every router with
+	// LBLight.AddResponseTime.
+	AddResponseTime bool
+
+	// DebugErrors lists every backend tried, and why it failed, in the body of the 502 sent when
+	// all attempts fail. Handy while debugging, but leaks backend details to clients.
+	DebugErrors bool
+
+	// StatusRewrite maps backend response status codes to the code sent to the client, eg {418: 400}.
+	// Circuit breaking and health scoring still go by the backends real status.
+	StatusRewrite map[int]int
+
+	// FlushInterval is how often response bodies are flushed to the client while being copied.
+	// 0 leaves it to ReverseProxy which buffers, except for streaming responses (Server-Sent Events,
+	// ie Content-Type text/event-stream, or unknown length) which are flushed as each chunk arrives.
+	// Negative flushes after every write.
+	FlushInterval time.Duration
+
+	// BufferResponsesUnder reads responses smaller than this many bytes from the backend in full
+	// before sending them, in a single write with a Content-Length, rather than streaming them.
+	// Bigger responses, and Server-Sent Events, still stream. 0 (default) streams everything.
+	BufferResponsesUnder int64
+
+	// FailurePolicy configures retries and circuit breaking. nil means no retries and no breakers.
+	FailurePolicy *FailurePolicy
+
+	// BackendProtocol is the HTTP version used to talk to the backends, one of BackendProtocolAuto
+	// (default, match the client where possible), BackendProtocolHTTP1 or BackendProtocolHTTP2.
+	BackendProtocol string
+
+	// DisableKeepAlives makes every request to the backends use a new connection. Useful for
+	// backends that don't cope well with reused connections.
+	DisableKeepAlives bool
+
+	// ExpectContinueTimeout is how long to wait for the backend to reply 100 Continue to a request
+	// with "Expect: 100-continue" before sending the body regardless. 0 keeps the default (1 second),
+	// negative sends the body immediately without waiting.
+	ExpectContinueTimeout time.Duration
+
+	// DialTimeout bounds connecting to a backend, so an unreachable backend fails fast (502) without
+	// waiting on slow backends to respond. Defaults to 30 seconds.
+	DialTimeout time.Duration
+
+	// ResponseHeaderTimeout is how long to wait for a backends response headers once the request
+	// has been sent. 0 (default) waits as long as the request allows.
+	ResponseHeaderTimeout time.Duration
+
+	// Transport replaces the transport used to reach the backends (proxied requests and health checks),
+	// eg for a custom dialer or instrumentation. When set the other transport options (DisableKeepAlives,
+	// ExpectContinueTimeout, TLSServerName, BackendProtocol, DNSResolver, DialTimeout, ResponseHeaderTimeout) are ignored.
+	Transport http.RoundTripper
+
+	// TLSServerName is the SNI (and the name the certificate is verified against) sent to https
+	// backends, for when it differs from the host being dialled. Empty uses the dial host.
+	TLSServerName string
+
+	// count of backends ever created, used for backend IDs.
+	backendsCreated int
+
+	// set by ReplaceBackends, new backends (lazily created or topping up MinBackends) are made for
+	// these instead of host/port.
+	replacementURIs []string
+
+	// transport shared by all backends. Created with the first backend.
+	transport http.RoundTripper
+
+	// Resolver, if set, discovers the backends for the router instead of creating them on demand
+	// for host/port. The backend list is refreshed every ResolveInterval (default 30 seconds).
+	Resolver        Resolver
+	ResolveInterval time.Duration
+
+	// closed when the router is closed, stops any background goroutines.
+	done chan struct{}
+	closeOnce sync.Once
+	startOnce sync.Once
+
+	// StickySession pins clients to a backend with a cookie. nil disables sticky sessions.
+	StickySession *StickySessionConfig
+
+	// QueueTimeout makes requests wait up to this long for a backend to be released when they're all
+	// busy, instead of failing straight away. 0 disables queueing.
+	QueueTimeout time.Duration
+
+	// QueueDepth is the most requests that can be queued at once, any more get ErrQueueFull.
+	// 0 is unlimited.
+	QueueDepth int
+
+	// ClientRateLimit limits the request rate of each client IP (see UseForwardedFor), or whatever
+	// its KeyFunc identifies clients by. nil is unlimited.
+	ClientRateLimit *RateLimitConfig
+	rateLimiter     *rateLimiter
+	rateLimiterOnce sync.Once
+
+	// MaxConcurrentRequests caps how many of the routers requests are proxied at once, whatever the
+	// backends could take, so one router can't hog a backend pool shared with others. Requests over the
+	// cap queue in arrival order, for up to ConcurrencyQueueTimeout (0 waits as long as the request
+	// allows) before getting a 503. 0 (default) is no cap.
+	MaxConcurrentRequests   int
+	ConcurrencyQueueTimeout time.Duration
+	concurrency             *fairSemaphore
+	concurrencyOnce         sync.Once
+
+	// set by Pause, guarded by mux.
+	paused bool
+
+	// number of requests queued, and the channel closed to wake them when a backend is released.
+	queued   int
+	released chan struct{}
+
+	// guards backends and their InUse flags.
+	mux sync.Mutex
 }
 
 func NewBackendRouter(host string, port int, acceptedHeaders map[string]string, acceptedPaths map[string]bool, maxBackends int) *BackendRouter {
@@ -59,29 +497,432 @@ func NewBackendRouter(host string, port int, acceptedHeaders map[string]string,
 	ber.acceptedHeaders = acceptedHeaders
 	ber.acceptedPaths = acceptedPaths
 	ber.maxBackends = maxBackends
+	ber.AllowLazyCreation = true
+	ber.done = make(chan struct{})
 	return &ber
 }
 
+// start kicks off any background work for the router. Safe to call multiple times.
+func (ber *BackendRouter) start() {
+	ber.startOnce.Do(func() {
+		if ber.Resolver != nil {
+			ber.refreshBackends()
+			go ber.runResolver()
+			return
+		}
+
+		ber.mux.Lock()
+		ber.ensureMinBackends()
+		ber.mux.Unlock()
+	})
+}
+
+// ensureMinBackends tops the pool up to MinBackends. Caller must hold the lock.
+func (ber *BackendRouter) ensureMinBackends() {
+	if ber.Resolver != nil {
+		return
+	}
+	for len(ber.backends) < ber.MinBackends {
+		be := ber.newBackend(ber.backendURI())
+		log.Infof("Adding backend %s to router %s to keep %d backends", be.ID, ber.String(), ber.MinBackends)
+		ber.backends = append(ber.backends, be)
+	}
+	ber.notifyReleased()
+}
+
+// RemoveBackend takes be out of the pool, stopping its health checks. Requests already using it
+// carry on. The pool is topped back up to MinBackends, if set.
+func (ber *BackendRouter) RemoveBackend(be *Backend) error {
+	ber.mux.Lock()
+	defer ber.mux.Unlock()
+
+	for i, existing := range ber.backends {
+		if existing == be {
+			ber.backends = append(append([]*Backend{}, ber.backends[:i]...), ber.backends[i+1:]...)
+			close(be.stopped)
+			log.Infof("Removed backend %s from router %s", be.ID, ber.String())
+			ber.ensureMinBackends()
+			return nil
+		}
+	}
+	return fmt.Errorf("backend %s not in router %s", be.ID, ber.String())
+}
+
+// closeIdleConnections closes any idle connections to the backends.
+func (ber *BackendRouter) closeIdleConnections() {
+	ber.mux.Lock()
+	defer ber.mux.Unlock()
+
+	if closer, ok := ber.transport.(idleCloser); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// Close stops any background work (eg DNS refreshing) for the router.
+func (ber *BackendRouter) Close() {
+	ber.closeOnce.Do(func() {
+		close(ber.done)
+	})
+}
+
+// newBackend creates a backend configured with the routers options. Caller must hold the lock.
+func (ber *BackendRouter) newBackend(uri string) *Backend {
+	if ber.transport == nil {
+		ber.transport = ber.newTransport()
+	}
+
+	be := NewBackend(uri)
+	be.stopped = make(chan struct{})
+	be.ID = fmt.Sprintf("%s/%d", ber.String(), ber.backendsCreated)
+	ber.backendsCreated++
+	be.ReverseProxy.Transport = ber.transport
+	be.ReverseProxy.FlushInterval = ber.FlushInterval
+	be.ReverseProxy.Director = ber.director(be.ReverseProxy.Director)
+	if breakerConfig := ber.failurePolicy().CircuitBreaker; breakerConfig != nil {
+		be.breaker = newCircuitBreaker(*breakerConfig)
+	}
+	be.scoreLatency = ber.healthScoreLatency()
+	be.responseSizes = newSizeHistogram()
+	be.ReverseProxy.ModifyResponse = ber.modifyResponse(be)
+	be.ReverseProxy.ErrorHandler = be.handleProxyError
+
+	// without health checks nothing would ever mark the backend alive, so assume it is. With them
+	// it stays dead until the first probe passes.
+	be.Alive = ber.HealthCheck == nil
+	if ber.HealthCheck != nil {
+		be.healthChecked = true
+		be.firstProbeDone = make(chan struct{})
+		go ber.runHealthChecks(be)
+	}
+	return be
+}
+
+// backendURI is the URI for backends created for the routers host/port. After ReplaceBackends it's
+// whichever of the replacement URIs has the fewest backends, so the pool grows evenly across the new set.
+// Caller must hold the lock.
+func (ber *BackendRouter) backendURI() string {
+	if len(ber.replacementURIs) > 0 {
+		counts := make(map[string]int)
+		for _, be := range ber.backends {
+			counts[be.url.String()]++
+		}
+		uri := ber.replacementURIs[0]
+		for _, candidate := range ber.replacementURIs[1:] {
+			if counts[candidate] < counts[uri] {
+				uri = candidate
+			}
+		}
+		return uri
+	}
+
+	basePath := ber.BasePath
+	if basePath != "" && !strings.HasPrefix(basePath, "/") {
+		basePath = "/" + basePath
+	}
+	return fmt.Sprintf("http://%s:%d%s", ber.host, ber.port, basePath)
+}
+
+// String identifies the router by the host/port it points at.
+func (ber *BackendRouter) String() string {
+	return fmt.Sprintf("%s:%d", ber.host, ber.port)
+}
+
 // GetBackend either retrieves backend from a pool OR adds new entry to pool (or errors out)
-// TODO(kpfaulkner) add locking.
+// The backend is marked InUse until ReleaseBackend is called.
 func (ber *BackendRouter) GetBackend() (*Backend, error ) {
+	return ber.GetBackendForRequest(nil)
+}
+
+// GetBackendForRequest is GetBackend but lets the routers Strategy use the request to pick
+// the backend. req can be nil, in which case the first available backend is used.
+// If QueueTimeout is set and every backend is busy, waits for one to be released.
+func (ber *BackendRouter) GetBackendForRequest(req *http.Request) (*Backend, error) {
+	ber.mux.Lock()
+	defer ber.mux.Unlock()
+
+	be, err := ber.getBackend(req)
+	if err != nil && ber.QueueTimeout > 0 && busyError(err) {
+		return ber.queueForBackend(req)
+	}
+	return be, err
+}
+
+// getBackend does the work for GetBackendForRequest, without queueing. Caller must hold the lock.
+func (ber *BackendRouter) getBackend(req *http.Request) (*Backend, error) {
+	// trusted requests can pick their backend, but only one the router already has.
+	if forced, ok := ber.forcedBackend(req); ok {
+		return ber.getForcedBackend(forced)
+	}
+
 	// check if we have any backends spare. If so, use it.
-	for index,be := range ber.backends {
+	if be := ber.selectBackend(req); be != nil {
+		be.take()
+		return be, nil
+	}
+
+	// If there are spare ones that are unhealthy (dead or breaker open) then the backend host
+	// is failing (or still starting) and it's pointless making another backend for it.
+	for _, be := range ber.backends {
 		if !be.InUse {
-			ber.backends[index].InUse = true
-			return be, nil
+			return nil, fmt.Errorf("unable to provide backend for request, no healthy backends")
 		}
 	}
 
-	// if none spare but haven't hit maxBackends yet, make one
-	if len(ber.backends) <= ber.maxBackends {
-		be := NewBackend(fmt.Sprintf("http://%s:%d", ber.host, ber.port))
+	// if none spare but haven't hit maxBackends yet, make one. Not if a resolver is
+	// maintaining the list though.
+	if ber.canCreateBackend() {
+		if ber.MaxConcurrentCreations > 0 && ber.warmingBackends() >= ber.MaxConcurrentCreations {
+			return nil, ErrPoolExhausted
+		}
+
+		be := ber.newBackend(ber.backendURI())
+		be.warming = true
 		ber.backends = append(ber.backends, be)
+
+		// health checked backends have to pass their probes first.
+		if be.healthChecked {
+			return nil, fmt.Errorf("unable to provide backend for request, new backend not alive yet")
+		}
+		be.take()
 		return be, nil
 	}
 
+	if !ber.AllowLazyCreation {
+		return nil, ErrNoBackendAvailable
+	}
+
 	// if cant make any more, return error.
-	return nil, fmt.Errorf("unable to provide backend for request")
+	return nil, ErrPoolExhausted
+}
+
+// matches checks the request against ALL of the routers criteria. Only relevant for MatchAll,
+// for MatchAny the router has already matched by the time we get here.
+func (ber *BackendRouter) matches(req *http.Request) bool {
+	if ber.MatchMode != MatchAll {
+		return true
+	}
+
+	if len(ber.AcceptedHosts) > 0 && !ber.matchesHost(requestHost(req)) {
+		return false
+	}
+
+	if len(ber.acceptedPaths) > 0 {
+		pathMatched := false
+		for path, _ := range ber.acceptedPaths {
+			if ber.hasPathPrefix(req.URL.Path, path) {
+				pathMatched = true
+				break
+			}
+		}
+		if !pathMatched {
+			return false
+		}
+	}
+
+	for header, val := range ber.acceptedHeaders {
+		if req.Header.Get(header) != val {
+			return false
+		}
+	}
+	return ber.matchesHeaderPrefixes(req) && ber.matchesHeaderPatterns(req)
+}
+
+// AddBackend explicitly adds a backend for uri (which doesn't have to be the routers host/port)
+// with the given metadata. Explicitly added backends aren't limited by maxBackends.
+func (ber *BackendRouter) AddBackend(uri string, metadata map[string]string) *Backend {
+	ber.mux.Lock()
+	defer ber.mux.Unlock()
+
+	be := ber.newBackend(uri)
+	be.Metadata = metadata
+	ber.backends = append(ber.backends, be)
+	ber.notifyReleased()
+	return be
+}
+
+// ReplaceBackends swaps the routers whole backend set for backends at newURIs in one go (eg for a
+// blue-green switch). New requests only go to the new set straight away, while requests in flight
+// on the old set are given up to drain to finish before the old backends are stopped.
+// Any backends created from then on (lazily, or to keep MinBackends) are for the new set too.
+// If the router is health checked the replacements get 503s until their first probe passes, so
+// make sure the new set is up before switching (or use WaitForReady).
+// Blocks until the old set has drained (or drain is up).
+func (ber *BackendRouter) ReplaceBackends(newURIs []string, drain time.Duration) error {
+	if ber.Resolver != nil {
+		return fmt.Errorf("unable to replace backends for router %s, backends are provided by its resolver", ber.String())
+	}
+	for _, uri := range newURIs {
+		if _, err := url.Parse(uri); err != nil {
+			return fmt.Errorf("invalid backend %s for router %s : %s", uri, ber.String(), err.Error())
+		}
+	}
+
+	ber.mux.Lock()
+	old := ber.backends
+	replacements := []*Backend{}
+	for _, uri := range newURIs {
+		replacements = append(replacements, ber.newBackend(uri))
+	}
+	ber.backends = replacements
+	ber.replacementURIs = []string{}
+	for _, be := range replacements {
+		ber.replacementURIs = append(ber.replacementURIs, be.url.String())
+	}
+	ber.ensureMinBackends()
+	ber.mux.Unlock()
+	log.Infof("Replaced %d backends with %d for router %s", len(old), len(replacements), ber.String())
+
+	timer := time.NewTimer(drain)
+	defer timer.Stop()
+drain:
+	for {
+		ber.mux.Lock()
+		inFlight := 0
+		for _, be := range old {
+			if be.InUse {
+				inFlight++
+			}
+		}
+		released := ber.releasedChan()
+		ber.mux.Unlock()
+
+		if inFlight == 0 {
+			break
+		}
+
+		select {
+		case <-released:
+		case <-timer.C:
+			log.Warnf("Stopping old backends for router %s with %d requests still in flight", ber.String(), inFlight)
+			break drain
+		}
+	}
+
+	for _, be := range old {
+		close(be.stopped)
+	}
+	ber.closeIdleConnections()
+	return nil
+}
+
+// WarmPool creates n backends up front (instead of waiting for traffic to create them) and, if
+// health checking is enabled, waits for each to be probed once. This avoids the latency of creating
+// backends during the first burst of traffic. Backends already in the pool count towards n.
+func (ber *BackendRouter) WarmPool(n int) error {
+	if ber.Resolver != nil {
+		return fmt.Errorf("unable to warm pool for router %s, backends are provided by its resolver", ber.String())
+	}
+
+	ber.mux.Lock()
+	if n > ber.maxBackends {
+		ber.mux.Unlock()
+		return fmt.Errorf("unable to warm pool for router %s, %d backends requested but max is %d", ber.String(), n, ber.maxBackends)
+	}
+
+	for len(ber.backends) < n {
+		ber.backends = append(ber.backends, ber.newBackend(ber.backendURI()))
+	}
+	backends := append([]*Backend{}, ber.backends...)
+	ber.mux.Unlock()
+
+	for _, be := range backends {
+		if be.healthChecked {
+			<-be.firstProbeDone
+		}
+	}
+	return nil
+}
+
+// Pause stops the router sending traffic to its backends, without unregistering it. Requests get
+// its MaintenanceResponse if it has one, otherwise a 503 with Retry-After, until Resume is called.
+func (ber *BackendRouter) Pause() {
+	ber.mux.Lock()
+	defer ber.mux.Unlock()
+	ber.paused = true
+}
+
+// Resume undoes Pause.
+func (ber *BackendRouter) Resume() {
+	ber.mux.Lock()
+	defer ber.mux.Unlock()
+	ber.paused = false
+}
+
+// Paused reports if the router has been paused.
+func (ber *BackendRouter) Paused() bool {
+	ber.mux.Lock()
+	defer ber.mux.Unlock()
+	return ber.paused
+}
+
+// inMaintenance reports if the router has a maintenance response and no backends to serve with.
+func (ber *BackendRouter) inMaintenance() bool {
+	if ber.MaintenanceResponse == nil {
+		return false
+	}
+
+	ber.mux.Lock()
+	defer ber.mux.Unlock()
+	return len(ber.backends) == 0 && !ber.canCreateBackend()
+}
+
+// failurePolicy returns the routers FailurePolicy, or an empty one if not set.
+func (ber *BackendRouter) failurePolicy() *FailurePolicy {
+	if ber.FailurePolicy == nil {
+		return &FailurePolicy{}
+	}
+	return ber.FailurePolicy
+}
+
+func (ber *BackendRouter) retryAfterSeconds() int {
+	if ber.RetryAfterSeconds <= 0 {
+		return 1
+	}
+	return ber.RetryAfterSeconds
+}
+
+// canCreateBackend reports if GetBackend is allowed to add another backend to the pool.
+// Caller must hold the lock.
+func (ber *BackendRouter) canCreateBackend() bool {
+	return ber.AllowLazyCreation && ber.Resolver == nil && len(ber.backends) < ber.maxBackends
+}
+
+// matchesHost checks host against the routers accepted hosts, including wildcards.
+func (ber *BackendRouter) matchesHost(host string) bool {
+	lowerHost := strings.ToLower(host)
+	for _, accepted := range ber.AcceptedHosts {
+		accepted = strings.ToLower(accepted)
+		if accepted == lowerHost {
+			return true
+		}
+		if strings.HasPrefix(accepted, "*.") && strings.HasSuffix(lowerHost, accepted[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReleaseBackend returns the backend to the pool so it can be used by another request.
+func (ber *BackendRouter) ReleaseBackend(be *Backend) {
+	ber.mux.Lock()
+	defer ber.mux.Unlock()
+	be.InUse = false
+	be.warming = false
+	if be.breaker != nil {
+		be.breaker.end()
+	}
+	ber.notifyReleased()
+}
+
+// warmingBackends counts the lazily created backends still on their first request. Caller must hold the lock.
+func (ber *BackendRouter) warmingBackends() int {
+	warming := 0
+	for _, be := range ber.backends {
+		if be.warming {
+			warming++
+		}
+	}
+	return warming
 }
 
 // LBLight is the core of the load balancer.
@@ -94,25 +935,216 @@ type LBLight struct {
 	pathPrefixToBackendRouter map[string]*BackendRouter
 
 	// match header KEY to a potential router
-	headerToBackendRouter map[string]map[string]*BackendRouter
+	// Normally one router per value, but several routers with a HeaderWeight can share a value.
+	headerToBackendRouter map[string]map[string][]*BackendRouter
+
+	// header value prefixes, longest match wins. Checked after the exact header matches.
+	headerPrefixes []headerPrefix
+
+	// header regexes, checked in registration order after the exact header and prefix matches.
+	headerPatterns []headerPattern
+
+	// match host (exact or *.wildcard) to router
+	hostToBackendRouter map[string]*BackendRouter
+
+	// match value extracted from the body (by BodyRouter) to router
+	bodyValueToBackendRouter map[string]*BackendRouter
+
+	// every router registered, in registration order.
+	routers []*BackendRouter
+
+	// ReadTimeout, ReadHeaderTimeout, WriteTimeout and IdleTimeout are passed through to the
+	// http.Server serving traffic. 0 means no timeout, which leaves the LB open to clients
+	// trickling requests in (Slowloris), so setting at least ReadHeaderTimeout is recommended.
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// MaxHeaderBytes caps the size of request headers (including the request line). Requests over
+	// the limit get a 431 Request Header Fields Too Large. 0 uses the net/http default of 1MB.
+	MaxHeaderBytes int
+
+	// TLSMinVersion is the lowest TLS version clients can use, eg tls.VersionTLS12. 0 uses the
+	// crypto/tls default. TLSCipherSuites restricts the cipher suites for TLS 1.2 and below (TLS 1.3
+	// suites aren't configurable), nil allows the crypto/tls defaults.
+	TLSMinVersion   uint16
+	TLSCipherSuites []uint16
+
+	// TLSNextProtos are the protocols advertised over ALPN, most preferred first, eg []string{"http/1.1"}
+	// to turn off HTTP/2. nil advertises h2 and http/1.1.
+	TLSNextProtos []string
+
+	// RejectUncleanPaths returns 400 for requests whose path contains . or .. segments (including
+	// double encoded ones) instead of just cleaning the path before routing.
+	RejectUncleanPaths bool
+
+	// RedirectTrailingSlash sends a 308 Permanent Redirect instead of a 404 when the path doesn't route
+	// but does with a trailing slash added (or removed), eg /api to /api/.
+	RedirectTrailingSlash bool
+
+	// MaxRequestTimeout enables clients setting their own deadline with the X-Request-Timeout header
+	// (eg "2s", "500ms" or plain seconds). The deadline is capped at MaxRequestTimeout and requests
+	// that exceed it get a 504. 0 ignores the header.
+	MaxRequestTimeout time.Duration
+
+	// RouteOrder is the precedence of route types when a request could match several routers,
+	// eg []string{RouteByHeader, RouteByPath}. Types left out aren't used for routing at all.
+	// Defaults to body, host, path, header.
+	RouteOrder []string
+
+	// ConflictPolicy is what AddBackendRouter does when a route is already registered. Defaults to ConflictError.
+	ConflictPolicy ConflictPolicy
+
+	// RequestObserver (if set) gets callbacks as each request is started, sent to a backend,
+	// responded to and finished.
+	RequestObserver RequestObserver
+	requestIDs      uint64
+
+	// AccessLog logs a line for every request (method, uri, status, bytes in and out, duration) to
+	// this logger. nil disables access logging.
+	AccessLog *log.Logger
+
+	// AddServedBy adds X-Served-By to the responses of every router (see BackendRouter.AddServedBy).
+	AddServedBy bool
+
+	// AddResponseTime adds X-Response-Time to the responses of every router (see BackendRouter.AddResponseTime).
+	AddResponseTime bool
+
+	// MaxHops detects routing loops (eg a backend pointing back at the LB). Each pass through the LB
+	// is counted in the X-LB-Hops request header and requests that have already made MaxHops passes
+	// get a 508 Loop Detected. 0 (default) disables the check.
+	MaxHops int
+
+	// BodyRouter enables routing on the request body, to routers with AcceptedBodyValues. nil disables it.
+	BodyRouter *BodyRouter
+
+	// RequestFilter is run on every request before it's routed. Rejected requests never reach a router.
+	RequestFilter RequestFilter
+
+	// set by SetRouteFunc.
+	routeFunc func(*http.Request) (*BackendRouter, error)
+
+	// server is the http.Server serving traffic, set once ListenAndServeTraffic is called.
+	server *http.Server
+
+	// BufferBudget caps the total bytes of request bodies buffered (BufferRequestBody, or peeked by the
+	// BodyRouter) at once across every router. Bodies that would go over it are streamed instead (so can't be retried), or
+	// rejected with a 503 if RejectOverBufferBudget is set. 0 is unlimited.
+	BufferBudget           int64
+	RejectOverBufferBudget bool
+
+	// bytes of buffered request bodies currently held, used atomically.
+	bufferedBytes int64
+
+	// ProxyProtocol expects every connection to start with a PROXY protocol (v1 or v2) header, as sent
+	// by HAProxy, ELB etc when the LB sits behind them. The client address from the header is used
+	// as the requests RemoteAddr (so for X-Forwarded-For, logging etc).
+	ProxyProtocol bool
+
+	// TCPKeepAlive is the TCP keep-alive period set on accepted client connections. 0 leaves the
+	// net package default (15 seconds), negative disables keep-alive.
+	TCPKeepAlive time.Duration
+
+	// DrainFile is a path that, while it exists, puts the LB in drain mode: Ready (and /readyz)
+	// report not ready so an upstream LB takes it out of rotation. With DrainRefuseConnections new
+	// client connections are closed as well, existing ones carry on.
+	DrainFile              string
+	DrainRefuseConnections bool
+
+	// ShutdownTimeout is how long RunAll waits for in flight requests when shutting down. Default 30 seconds.
+	ShutdownTimeout time.Duration
+
+	// tracks the client connections to server.
+	conns *connTracker
+
+	// requests currently with a backend.
+	inflight *inflightTracker
+
+	// guards the router maps, routers and server above.
+	mux sync.RWMutex
 }
 
 func NewLBLight(port int) *LBLight {
 	lbl := LBLight{}
 	lbl.pathPrefixToBackendRouter = make(map[string]*BackendRouter)
-	lbl.headerToBackendRouter = make(map[string]map[string]*BackendRouter)
+	lbl.headerToBackendRouter = make(map[string]map[string][]*BackendRouter)
+	lbl.hostToBackendRouter = make(map[string]*BackendRouter)
+	lbl.bodyValueToBackendRouter = make(map[string]*BackendRouter)
+	lbl.conns = newConnTracker()
+	lbl.inflight = newInflightTracker()
 
 	lbl.port = port
 	return &lbl
 }
 
+// RouteInfo describes a single registered route.
+type RouteInfo struct {
+	// Type is RouteByHost, RouteByPath, RouteByHeader or RouteByBody.
+	Type string
+
+	// Match is the host, path prefix or header name.
+	Match string
+
+	// Value is the header value for header routes, empty otherwise.
+	Value string
+
+	// Router identifies the BackendRouter the route goes to.
+	Router string
+}
+
+// Routes returns every registered host, path prefix and header route, sorted by type then match.
+func (l *LBLight) Routes() []RouteInfo {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+
+	routes := []RouteInfo{}
+	for host, router := range l.hostToBackendRouter {
+		routes = append(routes, RouteInfo{Type: RouteByHost, Match: host, Router: router.String()})
+	}
+	for path, router := range l.pathPrefixToBackendRouter {
+		routes = append(routes, RouteInfo{Type: RouteByPath, Match: path, Router: router.String()})
+	}
+	for value, router := range l.bodyValueToBackendRouter {
+		routes = append(routes, RouteInfo{Type: RouteByBody, Match: value, Router: router.String()})
+	}
+	for header, headerValues := range l.headerToBackendRouter {
+		for val, routers := range headerValues {
+			for _, router := range routers {
+				routes = append(routes, RouteInfo{Type: RouteByHeader, Match: header, Value: val, Router: router.String()})
+			}
+		}
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Type != routes[j].Type {
+			return routes[i].Type < routes[j].Type
+		}
+		if routes[i].Match != routes[j].Match {
+			return routes[i].Match < routes[j].Match
+		}
+		if routes[i].Value != routes[j].Value {
+			return routes[i].Value < routes[j].Value
+		}
+		return routes[i].Router < routes[j].Router
+	})
+	return routes
+}
+
 // GetBackendRouterByExactPathPrefix returns the backend router which is registered for the exact
 // match of "path". This is more for registration.
 func (l *LBLight) GetBackendRouterByExactPathPrefix(path string) (*BackendRouter, error) {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+
+	// a case sensitive router is registered under the path as is.
+	if backend, ok := l.pathPrefixToBackendRouter[path]; ok {
+		return backend, nil
+	}
 
 	lowerPath := strings.ToLower(path)
 	backend, ok := l.pathPrefixToBackendRouter[lowerPath]
-	if ok {
+	if ok && !backend.CaseSensitivePaths {
 		return backend, nil
 	}
 
@@ -123,119 +1155,689 @@ func (l *LBLight) GetBackendRouterByExactPathPrefix(path string) (*BackendRouter
 // searches each registered BackendRouter for a prefix match. This means it's NOT just a map lookup
 // but iterating over all of them looking for prefix matches. May need to rethink this a bit.
 func (l *LBLight) GetBackendRouterByPathPrefix(path string) (*BackendRouter, error) {
-	lowerPath := strings.ToLower(path)
-	for prefix, router := range l.pathPrefixToBackendRouter {
-		if strings.HasPrefix(lowerPath, prefix) {
-			return router, nil
-		}
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+
+	if router := l.lookupPathPrefix(path, nil); router != nil {
+		return router, nil
 	}
 
 	return nil, fmt.Errorf("Unable to find matching backend for path %s", path)
 }
 
 
+// GetBackendRouterByHost returns the backend router registered for host (the port, if any, is ignored).
+// Exact matches win, otherwise wildcards are tried from most to least specific, so
+// a.b.example.com checks *.b.example.com then *.example.com.
+func (l *LBLight) GetBackendRouterByHost(host string) (*BackendRouter, error) {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+
+	router, ok := l.lookupHost(stripPort(host))
+	if ok {
+		return router, nil
+	}
+	return nil, fmt.Errorf("Unable to find matching backend for host %s", host)
+}
+
+// lookupHost does the exact/wildcard host lookup. Caller must hold the lock.
+func (l *LBLight) lookupHost(host string) (*BackendRouter, bool) {
+	if len(l.hostToBackendRouter) == 0 || host == "" {
+		return nil, false
+	}
+
+	lowerHost := strings.ToLower(host)
+	if router, ok := l.hostToBackendRouter[lowerHost]; ok {
+		return router, true
+	}
+
+	labels := strings.Split(lowerHost, ".")
+	for i := 1; i < len(labels); i++ {
+		wildcard := "*." + strings.Join(labels[i:], ".")
+		if router, ok := l.hostToBackendRouter[wildcard]; ok {
+			return router, true
+		}
+	}
+	return nil, false
+}
+
+// requestHost returns the host the request was sent to, without any port.
+func requestHost(req *http.Request) string {
+	return stripPort(req.Host)
+}
+
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// GetBackendRouterByHeader returns the backend router registered for the exact header name and value,
+// falling back to the router with the longest HeaderPrefixes prefix of the value, then the first
+// router with a HeaderPatterns regex matching the value.
+// If several weighted routers share the value, one is picked at random according to their weights.
 func (l *LBLight) GetBackendRouterByHeader(headerName string, headerValue string) (*BackendRouter, error) {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
 
-	headerValues, ok := l.headerToBackendRouter[headerName]
+	headerValues, ok := l.headerToBackendRouter[http.CanonicalHeaderKey(headerName)]
 	if ok {
 		// have a match for header... now check specific value.
-		headerNameAndValueBackend, ok2 := headerValues[headerValue]
+		routers, ok2 := headerValues[headerValue]
 		if ok2 {
-			return headerNameAndValueBackend, nil
+			return pickWeightedRouter(routers), nil
 		}
 	}
 
+	if router := l.lookupHeaderPrefix(headerName, headerValue, nil); router != nil {
+		return router, nil
+	}
+
+	if router := l.lookupHeaderPattern(headerName, headerValue); router != nil {
+		return router, nil
+	}
+
 	return nil, fmt.Errorf("Unable to find matching backend for header %s : %s", headerName, headerValue)
 }
 
 // AddBackendRouter register a BackendRouter to both pathPrefix map and header maps for lookup
 // at runtime. If we have multiple, then we'd definitely NOT know who the request
 // really should go to. If any of the paths/headers fail for thie BER, then fail them all.
+// Registration happens under the LBLight lock, so either everything for this BER is registered
+// or nothing is. Once registered, any background work for the router (eg resolving backends) is started.
+// Its HeaderBuckets variants are started too, whether or not they've been added themselves.
 func (l *LBLight) AddBackendRouter(ber *BackendRouter) error {
+	absorbed, err := l.registerBackendRouter(ber)
+	if err != nil {
+		return err
+	}
+	if absorbed {
+		ber.stopAbsorbed()
+		return nil
+	}
 
-	// check if path/header already registered.
-	if ber.acceptedPaths != nil {
-		for path, _ := range ber.acceptedPaths {
-			_, err := l.GetBackendRouterByExactPathPrefix(path)
-			if err == nil {
-				// no error, we already have something registered!
-				return fmt.Errorf("Conflict: Backend path %s already registered", path)
+	ber.start()
+	for _, variant := range ber.variantRouters() {
+		variant.start()
+	}
+	return nil
+}
+
+// registerBackendRouter registers bers routes. absorbed is set if every one of them was merged into
+// other routers (ConflictMerge), in which case ber isn't registered itself.
+func (l *LBLight) registerBackendRouter(ber *BackendRouter) (absorbed bool, err error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	headerPatterns, err := ber.compileHeaderPatterns()
+	if err != nil {
+		return false, err
+	}
+
+	logTemplate, err := ber.compileLogFormat()
+	if err != nil {
+		return false, err
+	}
+
+	variants, err := l.checkVariantRouters(ber)
+	if err != nil {
+		return false, err
+	}
+
+	// conflicts only fail the registration with ConflictError, in which case nothing has been
+	// replaced and it's safe to roll back.
+	merged := make(map[*BackendRouter]bool)
+
+	headerPrefixes, err := l.checkHeaderPrefixes(ber, merged)
+	if err != nil {
+		return false, err
+	}
+
+	// register valid paths, remembering what we've done so we can undo it.
+	registeredPaths := []string{}
+	for path, _ := range ber.acceptedPaths {
+		key := ber.pathKey(path)
+		if existing, ok := l.pathPrefixToBackendRouter[key]; ok {
+			replace, err := l.handleConflict(existing, ber, fmt.Errorf("Conflict: Backend path %s already registered", path), merged)
+			if err != nil {
+				l.unregisterPaths(registeredPaths)
+				return false, err
+			}
+			if !replace {
+				continue
 			}
 		}
+		l.pathPrefixToBackendRouter[key] = ber
+		registeredPaths = append(registeredPaths, key)
 	}
 
-	// check headers.
-	if ber.acceptedHeaders != nil {
-		for header, val := range ber.acceptedHeaders {
-			_, err2 := l.GetBackendRouterByHeader(header, val)
-			if err2 == nil {
-				// no error, we already have something registered!
-				return fmt.Errorf("Conflict: Backend header %s : %s already registered", header, val)
+	// now headers. If any of these conflict, roll back the paths registered above
+	// along with any headers registered so far.
+	// Weighted routers can share a header value with other weighted routers.
+	registeredHeaders := make(map[string]string)
+	for h, val := range ber.acceptedHeaders {
+		header := http.CanonicalHeaderKey(h)
+		specificHeaderMap, ok := l.headerToBackendRouter[header]
+		if existing := specificHeaderMap[val]; len(existing) > 0 && (ber.HeaderWeight <= 0 || existing[0].HeaderWeight <= 0) {
+			replace, err := l.handleConflict(existing[0], ber, fmt.Errorf("Conflict: Backend header %s : %s already registered", header, val), merged)
+			if err != nil {
+				l.unregisterHeaders(registeredHeaders, ber)
+				l.unregisterPaths(registeredPaths)
+				return false, err
 			}
+			if !replace {
+				continue
+			}
+			delete(specificHeaderMap, val)
 		}
+
+		if !ok {
+			specificHeaderMap = make(map[string][]*BackendRouter)
+			l.headerToBackendRouter[header] = specificHeaderMap
+		}
+		specificHeaderMap[val] = append(specificHeaderMap[val], ber)
+		registeredHeaders[header] = val
 	}
 
-	// register valid paths/headers
-	if ber.acceptedPaths != nil {
-		for path, _ := range ber.acceptedPaths {
-			l.pathPrefixToBackendRouter[path] = ber
+	// and finally hosts.
+	registeredHosts := []string{}
+	for _, host := range ber.AcceptedHosts {
+		lowerHost := strings.ToLower(host)
+		if existing, ok := l.hostToBackendRouter[lowerHost]; ok {
+			replace, err := l.handleConflict(existing, ber, fmt.Errorf("Conflict: Backend host %s already registered", host), merged)
+			if err != nil {
+				l.unregisterHosts(registeredHosts)
+				l.unregisterHeaders(registeredHeaders, ber)
+				l.unregisterPaths(registeredPaths)
+				return false, err
+			}
+			if !replace {
+				continue
+			}
 		}
+		l.hostToBackendRouter[lowerHost] = ber
+		registeredHosts = append(registeredHosts, lowerHost)
 	}
 
-	if ber.acceptedHeaders != nil {
-		for header, val := range ber.acceptedHeaders {
-			//l.headerToBackendRouter[header][val] = ber
-			var specificHeaderMap map[string]*BackendRouter
-			var ok bool
-			specificHeaderMap,ok  = l.headerToBackendRouter[header]
-			if !ok {
-				//headerVal, ok2 := specificHeaderMap[val]
-				specificHeaderMap = make(map[string]*BackendRouter)
+	registeredBodyValues := []string{}
+	for _, value := range ber.AcceptedBodyValues {
+		if existing, ok := l.bodyValueToBackendRouter[value]; ok {
+			replace, err := l.handleConflict(existing, ber, fmt.Errorf("Conflict: Backend body value %s already registered", value), merged)
+			if err != nil {
+				l.unregisterBodyValues(registeredBodyValues)
+				l.unregisterHosts(registeredHosts)
+				l.unregisterHeaders(registeredHeaders, ber)
+				l.unregisterPaths(registeredPaths)
+				return false, err
+			}
+			if !replace {
+				continue
 			}
-			specificHeaderMap[val] = ber
 		}
+		l.bodyValueToBackendRouter[value] = ber
+		registeredBodyValues = append(registeredBodyValues, value)
+	}
+
+	if len(merged) > 0 && len(headerPrefixes) == 0 && len(registeredPaths) == 0 && len(registeredHeaders) == 0 &&
+		len(registeredHosts) == 0 && len(registeredBodyValues) == 0 && len(headerPatterns) == 0 {
+		return true, nil
+	}
+
+	l.registerHeaderPrefixes(headerPrefixes)
+	ber.logTemplate = logTemplate
+
+	// patterns can overlap, first registered wins, so they never conflict.
+	ber.headerPatterns = headerPatterns
+	l.headerPatterns = append(l.headerPatterns, headerPatterns...)
+
+	// a variant added after the router it belongs to is already listed.
+	if !l.isListed(ber) {
+		l.routers = append(l.routers, ber)
+	}
+	l.listVariantRouters(variants)
+	return false, nil
+}
+
+// unregisterPaths removes the path prefixes from the lookup map. Caller must hold the lock.
+func (l *LBLight) unregisterPaths(paths []string) {
+	for _, path := range paths {
+		delete(l.pathPrefixToBackendRouter, path)
 	}
+}
 
+// unregisterHosts removes the hosts from the lookup map. Caller must hold the lock.
+func (l *LBLight) unregisterHosts(hosts []string) {
+	for _, host := range hosts {
+		delete(l.hostToBackendRouter, host)
+	}
+}
+
+// unregisterHeaders removes ber from the header/value pairs in the lookup maps, dropping
+// any value or header map that ends up empty. Caller must hold the lock.
+func (l *LBLight) unregisterHeaders(headers map[string]string, ber *BackendRouter) {
+	for header, val := range headers {
+		specificHeaderMap, ok := l.headerToBackendRouter[header]
+		if !ok {
+			continue
+		}
+
+		remaining := []*BackendRouter{}
+		for _, router := range specificHeaderMap[val] {
+			if router != ber {
+				remaining = append(remaining, router)
+			}
+		}
+		if len(remaining) > 0 {
+			specificHeaderMap[val] = remaining
+		} else {
+			delete(specificHeaderMap, val)
+		}
+
+		if len(specificHeaderMap) == 0 {
+			delete(l.headerToBackendRouter, header)
+		}
+	}
+}
+
+// pickWeightedRouter picks one of the routers at random in proportion to their HeaderWeight.
+func pickWeightedRouter(routers []*BackendRouter) *BackendRouter {
+	if len(routers) == 1 {
+		return routers[0]
+	}
+
+	total := 0
+	for _, router := range routers {
+		total += router.HeaderWeight
+	}
+
+	pick := rand.Intn(total)
+	for _, router := range routers {
+		pick -= router.HeaderWeight
+		if pick < 0 {
+			return router
+		}
+	}
+	return routers[len(routers)-1]
+}
+
+// Route types, used for RouteOrder (and RouteInfo.Type).
+const (
+	RouteByHost   = "host"
+	RouteByPath   = "path"
+	RouteByHeader = "header"
+	RouteByBody   = "body"
+)
+
+// defaultRouteOrder is used when RouteOrder isn't set. Body routes only exist with a BodyRouter,
+// and are the most specific, so go first.
+var defaultRouteOrder = []string{RouteByBody, RouteByHost, RouteByPath, RouteByHeader}
+
+// SetRouteFunc installs a function that picks the router for every request ahead of the built in
+// host/path/header/body matching. Returning an error 404s the request, returning a nil router
+// falls back to the built in matching. The routers returned should be registered with
+// AddBackendRouter too, so they're started, shut down and show up in Stats.
+// nil removes the route func.
+func (l *LBLight) SetRouteFunc(routeFunc func(*http.Request) (*BackendRouter, error)) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.routeFunc = routeFunc
+}
+
+// getBackendRouter finds the router for the request, trying each type of route in RouteOrder
+// (body values, hosts, then path prefixes, then headers by default). Routers in MatchAll mode are skipped
+// unless the request satisfies all their criteria.
+func (l *LBLight) getBackendRouter(req *http.Request) (*BackendRouter, error) {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+
+	routeOrder := l.RouteOrder
+	if len(routeOrder) == 0 {
+		routeOrder = defaultRouteOrder
+	}
+
+	for _, routeType := range routeOrder {
+		var router *BackendRouter
+		switch routeType {
+		case RouteByHost:
+			router = l.matchHostRoute(req)
+		case RouteByPath:
+			router = l.matchPathRoute(req)
+		case RouteByHeader:
+			router = l.matchHeaderRoute(req)
+		case RouteByBody:
+			router = l.matchBodyRoute(req)
+		}
+		if router != nil {
+			return router, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unable to find matching backend for path %s", req.URL.Path)
+}
+
+// matchHostRoute, matchPathRoute and matchHeaderRoute look up the router for the request by
+// a single route type. Caller must hold the lock.
+func (l *LBLight) matchHostRoute(req *http.Request) *BackendRouter {
+	if router, ok := l.lookupHost(requestHost(req)); ok && router.matches(req) {
+		return router
+	}
 	return nil
 }
 
+func (l *LBLight) matchPathRoute(req *http.Request) *BackendRouter {
+	return l.lookupPathPrefix(req.URL.Path, req)
+}
 
+// lookupPathPrefix finds a router with a prefix of path, preferring case sensitive routers. With
+// req the router has to match all of it too (see matches). Caller must hold the lock.
+func (l *LBLight) lookupPathPrefix(path string, req *http.Request) *BackendRouter {
+	var insensitive *BackendRouter
+	for prefix, router := range l.pathPrefixToBackendRouter {
+		if !strings.HasPrefix(router.pathKey(path), prefix) || (req != nil && !router.matches(req)) {
+			continue
+		}
+		if router.CaseSensitivePaths {
+			return router
+		}
+		if insensitive == nil {
+			insensitive = router
+		}
+	}
+	return insensitive
+}
 
+func (l *LBLight) matchHeaderRoute(req *http.Request) *BackendRouter {
+	for header, headerValues := range l.headerToBackendRouter {
+		routers, ok := headerValues[req.Header.Get(header)]
+		if !ok {
+			continue
+		}
+		if router := pickWeightedRouter(routers); router.matches(req) {
+			return router
+		}
+	}
+	if router := l.matchHeaderPrefixRoute(req); router != nil {
+		return router
+	}
+	return l.matchHeaderPatternRoute(req)
+}
 
-// getBackend.... TODO(kpfaulkner) make real!
-// just gets first match for now.
-func (l *LBLight) getBackend(req *http.Request) (*Backend, error) {
+// routeRequest determines which BackendRouter should handle the request.
+func (l *LBLight) routeRequest(req *http.Request) (*BackendRouter, error) {
+	l.mux.RLock()
+	routeFunc := l.routeFunc
+	l.mux.RUnlock()
 
-	// just return first one
-	backendRouter, err := l.GetBackendRouterByPathPrefix( req.URL.Path)
-	if err != nil {
-		return nil, err
+	var backendRouter *BackendRouter
+	var err error
+	if routeFunc != nil {
+		if backendRouter, err = routeFunc(req); err != nil {
+			return nil, err
+		}
 	}
 
-	// check if we have a backend for this router... if not, make one.
-	backend, err := backendRouter.GetBackend()
-	return backend, err
+	if backendRouter == nil {
+		if backendRouter, err = l.getBackendRouter(req); err != nil {
+			return nil, err
+		}
+	}
+
+	// the router may hand some requests off to a variant.
+	if backendRouter.HeaderBuckets != nil {
+		if variant := backendRouter.HeaderBuckets.variantRouter(req); variant != nil {
+			backendRouter = variant
+		}
+	}
 
+	return backendRouter, nil
 }
 
 // handleRequestsAndRedirect determines which BackendRouter should be used for the incoming request.
 func (l *LBLight) handleRequestsAndRedirect(res http.ResponseWriter, req *http.Request) {
+	req = withReceived(req)
+
+	if l.AccessLog != nil {
+		var logAccess func()
+		res, req, logAccess = l.accessLogged(res, req)
+		defer logAccess()
+	}
+
+	if l.RequestObserver != nil {
+		var endObserved func()
+		res, req, endObserved = l.observed(res, req)
+		defer endObserved()
+	}
+
+	if l.rejectLoop(res, req) {
+		return
+	}
 
-	backend,err := l.getBackend(req)
+	// route (and forward) on the cleaned path, so traversal can't sneak into another router.
+	cleanedPath, dirty := normalizePath(req.URL.Path)
+	if dirty && l.RejectUncleanPaths {
+		log.Warnf("Rejecting request with unclean path %s", req.RequestURI)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if cleanedPath != req.URL.Path {
+		req.URL.Path = cleanedPath
+		req.URL.RawPath = ""
+	}
+
+	if timeout, ok := l.requestTimeout(req); ok {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	if rejectByFilter(l.RequestFilter, res, req) {
+		return
+	}
+
+	// peek outside the routing lock, the body may be slow to arrive.
+	if l.BodyRouter != nil {
+		var release func()
+		var peekErr error
+		req, release, peekErr = l.peekRequestBody(req)
+		defer release()
+		if errors.Is(peekErr, ErrBufferBudgetExceeded) {
+			if l.RejectOverBufferBudget {
+				log.Warnf("Rejecting URL %s, request body buffer budget exceeded", req.RequestURI)
+				res.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			log.Debugf("Routing URL %s without its body, request body buffer budget exceeded", req.RequestURI)
+		}
+	}
+
+	backendRouter, err := l.routeRequest(req)
 	if err != nil {
+		if l.RedirectTrailingSlash {
+			if location, ok := l.trailingSlashRedirect(req); ok {
+				http.Redirect(res, req, location, http.StatusPermanentRedirect)
+				return
+			}
+		}
 		log.Errorf("Unable to find backend for URL %s", req.RequestURI)
+		res.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	backend.ReverseProxy.ServeHTTP(res, req)
-	return
+	l.serveRouted(res, req, backendRouter)
 }
 
-func (l *LBLight) ListenAndServeTraffic() error {
+// serveRouted applies the routers own checks (methods, filter, rate limit, pause, faults, body buffering,
+// concurrency cap) to a request routed to it and proxies it if they all pass. Also used for requests falling
+// back to the routers FallbackRouter, so they get the fallbacks checks rather than skipping them.
+func (l *LBLight) serveRouted(res http.ResponseWriter, req *http.Request, backendRouter *BackendRouter) {
+	if state := accessLogFromContext(req); state != nil {
+		state.router = backendRouter
+	}
+
+	if rejectMethod(backendRouter, res, req) {
+		return
+	}
+
+	if rejectByFilter(backendRouter.RequestFilter, res, req) {
+		return
+	}
+
+	if backendRouter.rateLimited(res, req) {
+		log.Debugf("Rate limited client %s for URL %s", clientIP(req, backendRouter.UseForwardedFor), req.RequestURI)
+		return
+	}
+
+	if backendRouter.Paused() {
+		log.Debugf("Router %s paused, not proxying URL %s", backendRouter.String(), req.RequestURI)
+		if backendRouter.MaintenanceResponse != nil {
+			backendRouter.MaintenanceResponse.write(res)
+			return
+		}
+		res.Header().Set("Retry-After", strconv.Itoa(backendRouter.retryAfterSeconds()))
+		res.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if backendRouter.injectFault(res, req) {
+		return
+	}
+
+	// buffer before taking a backend, so a slow upload doesn't hold one.
+	// a body the BodyRouter peeked whole is already buffered (and counted against the budget).
+	if backendRouter.BufferRequestBody && req.GetBody == nil {
+		release, err := l.bufferRequestBody(req)
+		defer release()
+		if errors.Is(err, ErrBufferBudgetExceeded) {
+			if l.RejectOverBufferBudget {
+				log.Warnf("Rejecting URL %s, request body buffer budget exceeded", req.RequestURI)
+				res.Header().Set("Retry-After", strconv.Itoa(backendRouter.retryAfterSeconds()))
+				res.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			log.Debugf("Streaming URL %s unbuffered, request body buffer budget exceeded", req.RequestURI)
+		} else if err != nil {
+			log.Errorf("Unable to read request body for URL %s : %s", req.RequestURI, err.Error())
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	// an HTTP/1 server normally stops reading the request body once the response has started, which
+	// would cut off a streamed (chunked) upload as soon as the backend starts streaming back.
+	if req.ProtoMajor == 1 && req.ContentLength != 0 {
+		http.NewResponseController(res).EnableFullDuplex()
+	}
 
-	err := http.ListenAndServeTLS(fmt.Sprintf(":%d", l.port), "localhost.crt", "localhost.key", http.HandlerFunc(l.handleRequestsAndRedirect))
+	release := backendRouter.acquireConcurrencySlot(res, req)
+	if release == nil {
+		return
+	}
+	defer release()
+
+	if backendRouter.IdempotencyTTL > 0 && req.Header.Get(idempotencyHeader) != "" {
+		l.idempotent(res, req, backendRouter)
+		return
+	}
+
+	if backendRouter.CoalesceRequests && coalescable(req) {
+		l.coalesce(res, req, backendRouter)
+		return
+	}
+
+	l.proxy(res, req, backendRouter)
+}
+
+// requestTimeout returns the client requested deadline from the X-Request-Timeout header, capped at
+// MaxRequestTimeout. ok is false if there's no (valid) header or client deadlines aren't enabled.
+func (l *LBLight) requestTimeout(req *http.Request) (time.Duration, bool) {
+	header := req.Header.Get("X-Request-Timeout")
+	if l.MaxRequestTimeout <= 0 || header == "" {
+		return 0, false
+	}
+
+	timeout, err := time.ParseDuration(header)
 	if err != nil {
+		seconds, err2 := strconv.Atoi(header)
+		if err2 != nil {
+			log.Debugf("Ignoring invalid X-Request-Timeout %s", header)
+			return 0, false
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	if timeout <= 0 {
+		return 0, false
+	}
+	if timeout > l.MaxRequestTimeout {
+		timeout = l.MaxRequestTimeout
+	}
+	return timeout, true
+}
+
+// newServer creates the http.Server for the traffic port, configured from the LBLight options.
+func (l *LBLight) newServer() *http.Server {
+	server := &http.Server{}
+	server.Addr = fmt.Sprintf(":%d", l.port)
+	server.Handler = http.HandlerFunc(l.handleRequestsAndRedirect)
+	server.ReadTimeout = l.ReadTimeout
+	server.ReadHeaderTimeout = l.ReadHeaderTimeout
+	server.WriteTimeout = l.WriteTimeout
+	server.IdleTimeout = l.IdleTimeout
+	server.MaxHeaderBytes = l.MaxHeaderBytes
+	server.ConnState = l.conns.connState
+	server.TLSConfig = l.tlsConfig()
+	l.applyNextProtos(server)
+	return server
+}
+
+func (l *LBLight) ListenAndServeTraffic() error {
+	return l.serveTraffic(l.setupServer())
+}
+
+// setupServer creates the traffic server and keeps it for Shutdown.
+func (l *LBLight) setupServer() *http.Server {
+	server := l.newServer()
+	l.mux.Lock()
+	l.server = server
+	l.mux.Unlock()
+	return server
+}
+
+func (l *LBLight) serveTraffic(server *http.Server) error {
+	err := l.listenAndServe(server)
+	if err != nil && err != http.ErrServerClosed {
 		log.Errorf("SERVER BLEW UP!! %s", err.Error())
 	}
 	return err
 }
+
+// Shutdown gracefully stops the traffic server, waiting for in flight requests until ctx expires.
+// Then closes the idle keep-alive connections to every backend (rather than leaving them to time
+// out) and stops any background work for the routers.
+func (l *LBLight) Shutdown(ctx context.Context) error {
+	l.mux.RLock()
+	server := l.server
+	routers := append([]*BackendRouter{}, l.routers...)
+	l.mux.RUnlock()
+
+	var err error
+	if server != nil {
+		err = server.Shutdown(ctx)
+	}
+
+	for _, ber := range routers {
+		ber.closeIdleConnections()
+		ber.Close()
+	}
+	return err
+}
+
+// listenAndServe is http.Server.ListenAndServeTLS, with the listener wrapped for the listener options.
+func (l *LBLight) listenAndServe(server *http.Server) error {
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	return server.ServeTLS(l.wrapListener(ln), "localhost.crt", "localhost.key")
+}
@@ -0,0 +1,1013 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newBackendServer starts a backend serving handler, closed when the test ends.
+func newBackendServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// hostPort splits a test servers address into what NewBackendRouter wants.
+func hostPort(t *testing.T, srv *httptest.Server) (string, int) {
+	t.Helper()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Hostname(), port
+}
+
+// routerFor creates a router for the backend server accepting the path prefixes.
+func routerFor(t *testing.T, srv *httptest.Server, paths ...string) *BackendRouter {
+	t.Helper()
+	host, port := hostPort(t, srv)
+	acceptedPaths := make(map[string]bool)
+	for _, path := range paths {
+		acceptedPaths[path] = true
+	}
+	return NewBackendRouter(host, port, nil, acceptedPaths, 10)
+}
+
+// serveLB serves the LBs traffic handler over plain HTTP. The server is closed, and the routers
+// shut down, when the test ends.
+func serveLB(t *testing.T, l *LBLight) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(l.handleRequestsAndRedirect))
+	t.Cleanup(func() {
+		srv.Close()
+		l.Shutdown(context.Background())
+	})
+	return srv
+}
+
+// serveLBServer serves traffic through the LBs own http.Server (so with its server and listener
+// options) over plain HTTP, returning the address. Shut down when the test ends.
+func serveLBServer(t *testing.T, l *LBLight) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := l.setupServer()
+	go server.Serve(l.wrapListener(ln))
+	t.Cleanup(func() {
+		l.Shutdown(context.Background())
+		server.Close()
+	})
+	return ln.Addr().String()
+}
+
+// testCertificate generates a self-signed certificate (and key) for localhost, PEM encoded.
+func testCertificate(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// addRouter registers the router, failing the test if it can't be.
+func addRouter(t *testing.T, l *LBLight, ber *BackendRouter) {
+	t.Helper()
+	if err := l.AddBackendRouter(ber); err != nil {
+		t.Fatalf("AddBackendRouter: %s", err)
+	}
+}
+
+// doRequest sends req with the default client and returns the response along with its body.
+func doRequest(t *testing.T, req *http.Request) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %s", req.Method, req.URL, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body of %s %s: %s", req.Method, req.URL, err)
+	}
+	return resp, string(body)
+}
+
+// get is doRequest for a GET of uri.
+func get(t *testing.T, uri string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doRequest(t, req)
+}
+
+// waitFor polls cond for up to 2 seconds, failing the test with what if it never holds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// textHandler is a backend handler that always responds with body.
+func textHandler(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}
+}
+
+func TestAddBackendRouterConflictLeavesNoPartialRoutes(t *testing.T) {
+	l := NewLBLight(0)
+	first := NewBackendRouter("127.0.0.1", 9001, map[string]string{"X-Env": "prod"}, map[string]bool{"/first": true}, 1)
+	addRouter(t, l, first)
+	before := l.Routes()
+
+	// the paths register fine, the header then conflicts with first.
+	second := NewBackendRouter("127.0.0.1", 9002, map[string]string{"X-Env": "prod"}, map[string]bool{"/second": true, "/other": true}, 1)
+	second.AcceptedHosts = []string{"second.example.com"}
+	if err := l.AddBackendRouter(second); err == nil {
+		t.Fatal("expected header conflict")
+	}
+
+	if after := l.Routes(); !reflect.DeepEqual(before, after) {
+		t.Errorf("routes changed by failed registration\nbefore %v\nafter  %v", before, after)
+	}
+	if _, err := l.GetBackendRouterByPathPrefix("/second"); err == nil {
+		t.Error("path of failed registration still routes")
+	}
+	if len(l.Stats().Routers) != 1 {
+		t.Errorf("expected only the first router registered, got %d", len(l.Stats().Routers))
+	}
+}
+
+// Run with -race, routers are registered while requests are being routed and proxied.
+func TestAddBackendRouterWhileServing(t *testing.T) {
+	backend := newBackendServer(t, textHandler("ok"))
+	l := NewLBLight(0)
+	addRouter(t, l, routerFor(t, backend, "/r0"))
+	lb := serveLB(t, l)
+
+	const routers = 20
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				resp, err := http.Get(lb.URL + "/r0")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("expected 200 while registering, got %d", resp.StatusCode)
+					return
+				}
+				l.Stats()
+				l.Routes()
+			}
+		}()
+	}
+
+	for i := 1; i <= routers; i++ {
+		addRouter(t, l, routerFor(t, backend, fmt.Sprintf("/r%d", i)))
+	}
+	close(stop)
+	wg.Wait()
+
+	for i := 0; i <= routers; i++ {
+		if resp, _ := get(t, fmt.Sprintf("%s/r%d", lb.URL, i)); resp.StatusCode != http.StatusOK {
+			t.Errorf("/r%d: expected 200, got %d", i, resp.StatusCode)
+		}
+	}
+}
+
+func TestMatchAllNeedsPathAndHeaders(t *testing.T) {
+	backend := newBackendServer(t, textHandler("prod"))
+
+	l := NewLBLight(0)
+	host, port := hostPort(t, backend)
+	matchAll := NewBackendRouter(host, port, map[string]string{"X-Env": "prod"}, map[string]bool{"/api": true}, 1)
+	matchAll.MatchMode = MatchAll
+	addRouter(t, l, matchAll)
+	lb := serveLB(t, l)
+
+	tests := []struct {
+		name   string
+		path   string
+		env    string
+		status int
+	}{
+		{"path only", "/api/x", "", http.StatusNotFound},
+		{"header only", "/x", "prod", http.StatusNotFound},
+		{"wrong header", "/api/x", "dev", http.StatusNotFound},
+		{"path and header", "/api/x", "prod", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, lb.URL+tt.path, nil)
+		if tt.env != "" {
+			req.Header.Set("X-Env", tt.env)
+		}
+		if resp, _ := doRequest(t, req); resp.StatusCode != tt.status {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.status, resp.StatusCode)
+		}
+	}
+}
+
+func TestReadHeaderTimeoutClosesSlowClient(t *testing.T) {
+	l := NewLBLight(0)
+	l.ReadHeaderTimeout = 100 * time.Millisecond
+	addr := serveLBServer(t, l)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// start the request but never finish the headers.
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: lb\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("expected the LB to close the connection, got %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("connection closed after %s, expected about the 100ms ReadHeaderTimeout", elapsed)
+	}
+	if len(reply) > 0 && !strings.Contains(string(reply), "408") {
+		t.Errorf("expected nothing or a 408 before the close, got %q", reply)
+	}
+}
+
+// pathHandler is a backend handler that responds with the path it was sent.
+func pathHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(r.URL.Path))
+}
+
+func TestBackendBasePath(t *testing.T) {
+	backend := newBackendServer(t, pathHandler)
+
+	t.Run("BasePath", func(t *testing.T) {
+		l := NewLBLight(0)
+		ber := routerFor(t, backend, "/x")
+		ber.BasePath = "/base"
+		addRouter(t, l, ber)
+		lb := serveLB(t, l)
+
+		if _, body := get(t, lb.URL+"/x"); body != "/base/x" {
+			t.Errorf("backend got %q, expected /base/x", body)
+		}
+	})
+
+	t.Run("backend URL", func(t *testing.T) {
+		l := NewLBLight(0)
+		ber := routerFor(t, backend, "/x")
+		ber.AllowLazyCreation = false
+		ber.AddBackend(backend.URL+"/base", nil)
+		addRouter(t, l, ber)
+		lb := serveLB(t, l)
+
+		if _, body := get(t, lb.URL+"/x/y"); body != "/base/x/y" {
+			t.Errorf("backend got %q, expected /base/x/y", body)
+		}
+	})
+}
+
+func TestMaxHeaderBytes(t *testing.T) {
+	backend := newBackendServer(t, textHandler("ok"))
+	l := NewLBLight(0)
+	l.MaxHeaderBytes = 1024
+	addRouter(t, l, routerFor(t, backend, "/"))
+	addr := serveLBServer(t, l)
+
+	if resp, _ := get(t, "http://"+addr+"/"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for small headers, got %d", resp.StatusCode)
+	}
+
+	// net/http allows some slack over MaxHeaderBytes, so go well over.
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/", nil)
+	req.Header.Set("X-Big", strings.Repeat("x", 16*1024))
+	if resp, _ := doRequest(t, req); resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("expected 431 for oversized headers, got %d", resp.StatusCode)
+	}
+}
+
+func TestWarmPoolProbesBackends(t *testing.T) {
+	var probes int32
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			atomic.AddInt32(&probes, 1)
+		}
+	})
+
+	ber := routerFor(t, backend, "/")
+	ber.maxBackends = 5
+	ber.HealthCheck = &HealthCheckConfig{Path: "/healthz", Interval: time.Minute}
+	defer ber.Close()
+
+	if err := ber.WarmPool(3); err != nil {
+		t.Fatal(err)
+	}
+	stats := ber.stats()
+	if len(stats.Backends) != 3 {
+		t.Fatalf("expected 3 backends, got %d", len(stats.Backends))
+	}
+	for _, bs := range stats.Backends {
+		if !bs.Alive {
+			t.Errorf("backend %s not alive after WarmPool", bs.ID)
+		}
+	}
+	if n := atomic.LoadInt32(&probes); n != 3 {
+		t.Errorf("expected each backend probed once, got %d probes", n)
+	}
+
+	if err := ber.WarmPool(6); err == nil {
+		t.Error("expected an error warming past maxBackends")
+	}
+}
+
+func TestHostRouting(t *testing.T) {
+	l := NewLBLight(0)
+	for _, tt := range []struct {
+		name  string
+		hosts []string
+	}{
+		{"a", []string{"a.example.com"}},
+		{"b", []string{"b.example.com"}},
+		{"wildcard", []string{"*.example.com"}},
+	} {
+		ber := routerFor(t, newBackendServer(t, textHandler(tt.name)))
+		ber.AcceptedHosts = tt.hosts
+		addRouter(t, l, ber)
+	}
+	lb := serveLB(t, l)
+
+	for _, tt := range []struct {
+		host   string
+		status int
+		body   string
+	}{
+		{"a.example.com", http.StatusOK, "a"},
+		{"B.Example.com:8080", http.StatusOK, "b"},
+		{"c.example.com", http.StatusOK, "wildcard"},
+		{"x.a.example.com", http.StatusOK, "wildcard"},
+		{"example.com", http.StatusNotFound, ""},
+		{"a.example.org", http.StatusNotFound, ""},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, lb.URL+"/", nil)
+		req.Host = tt.host
+		resp, body := doRequest(t, req)
+		if resp.StatusCode != tt.status || (tt.body != "" && body != tt.body) {
+			t.Errorf("Host %s: expected %d %q, got %d %q", tt.host, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestMaintenanceResponse(t *testing.T) {
+	l := NewLBLight(0)
+	resolver := &stubResolver{}
+	ber := NewBackendRouter("127.0.0.1", 9000, nil, map[string]bool{"/": true}, 1)
+	ber.Resolver = resolver
+	ber.MaintenanceResponse = &StaticResponse{
+		StatusCode: http.StatusServiceUnavailable,
+		Header:     http.Header{"Content-Type": {"text/html"}},
+		Body:       []byte("<h1>Back soon</h1>"),
+	}
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	resp, body := get(t, lb.URL+"/")
+	if resp.StatusCode != http.StatusServiceUnavailable || body != "<h1>Back soon</h1>" {
+		t.Errorf("expected the maintenance page, got %d %q", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/html" {
+		t.Errorf("expected Content-Type text/html, got %q", ct)
+	}
+}
+
+func TestRoutesListsRegisteredRoutes(t *testing.T) {
+	l := NewLBLight(0)
+	api := NewBackendRouter("10.0.0.1", 8080, map[string]string{"x-env": "prod"}, map[string]bool{"/api": true, "/v2": true}, 1)
+	web := NewBackendRouter("10.0.0.2", 8080, nil, nil, 1)
+	web.AcceptedHosts = []string{"www.example.com"}
+	addRouter(t, l, api)
+	addRouter(t, l, web)
+
+	expected := []RouteInfo{
+		{Type: RouteByHeader, Match: "X-Env", Value: "prod", Router: "10.0.0.1:8080"},
+		{Type: RouteByHost, Match: "www.example.com", Router: "10.0.0.2:8080"},
+		{Type: RouteByPath, Match: "/api", Router: "10.0.0.1:8080"},
+		{Type: RouteByPath, Match: "/v2", Router: "10.0.0.1:8080"},
+	}
+	if routes := l.Routes(); !reflect.DeepEqual(routes, expected) {
+		t.Errorf("expected routes %+v, got %+v", expected, routes)
+	}
+}
+
+func TestHeaderWeightSplitsTraffic(t *testing.T) {
+	l := NewLBLight(0)
+	heavy := NewBackendRouter("10.0.0.1", 8080, map[string]string{"X-Tenant": "acme"}, nil, 1)
+	heavy.HeaderWeight = 70
+	light := NewBackendRouter("10.0.0.2", 8080, map[string]string{"X-Tenant": "acme"}, nil, 1)
+	light.HeaderWeight = 30
+	addRouter(t, l, heavy)
+	addRouter(t, l, light)
+
+	const requests = 10000
+	heavyCount := 0
+	for i := 0; i < requests; i++ {
+		ber, err := l.GetBackendRouterByHeader("X-Tenant", "acme")
+		if err != nil {
+			t.Fatalf("lookup failed: %s", err)
+		}
+		if ber == heavy {
+			heavyCount++
+		}
+	}
+	if share := float64(heavyCount) / requests; share < 0.67 || share > 0.73 {
+		t.Errorf("expected about 70%% of traffic on the heavier router, got %.1f%%", share*100)
+	}
+
+	unweighted := NewBackendRouter("10.0.0.3", 8080, map[string]string{"X-Tenant": "acme"}, nil, 1)
+	if err := l.AddBackendRouter(unweighted); err == nil {
+		t.Errorf("expected a router without HeaderWeight to conflict with the weighted ones")
+	}
+}
+
+func TestShutdownClosesIdleBackendConnections(t *testing.T) {
+	var closed int32
+	backend := httptest.NewUnstartedServer(textHandler("ok"))
+	backend.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			atomic.AddInt32(&closed, 1)
+		}
+	}
+	backend.Start()
+	t.Cleanup(backend.Close)
+
+	l := NewLBLight(0)
+	addRouter(t, l, routerFor(t, backend, "/"))
+	lb := serveLB(t, l)
+
+	if resp, body := get(t, lb.URL+"/"); resp.StatusCode != http.StatusOK || body != "ok" {
+		t.Fatalf("expected 200 ok, got %d %q", resp.StatusCode, body)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&closed); n != 0 {
+		t.Fatalf("expected the idle backend connection to stay open, %d closed", n)
+	}
+
+	if err := l.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %s", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&closed) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("backend never saw its idle connection close")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRequestTimeoutHeader(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	l := NewLBLight(0)
+	l.MaxRequestTimeout = 300 * time.Millisecond
+	addRouter(t, l, routerFor(t, backend, "/"))
+	lb := serveLB(t, l)
+
+	for _, tt := range []struct {
+		header   string
+		expected time.Duration
+	}{
+		{"100ms", 100 * time.Millisecond},
+		// capped at MaxRequestTimeout
+		{"10", 300 * time.Millisecond},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, lb.URL+"/", nil)
+		req.Header.Set("X-Request-Timeout", tt.header)
+		start := time.Now()
+		resp, _ := doRequest(t, req)
+		elapsed := time.Since(start)
+		if resp.StatusCode != http.StatusGatewayTimeout {
+			t.Errorf("X-Request-Timeout %s: expected 504, got %d", tt.header, resp.StatusCode)
+		}
+		if elapsed < tt.expected || elapsed > tt.expected+time.Second {
+			t.Errorf("X-Request-Timeout %s: expected the 504 after about %s, took %s", tt.header, tt.expected, elapsed)
+		}
+	}
+}
+
+func TestRouteOrderHeaderFirst(t *testing.T) {
+	pathBackend := newBackendServer(t, textHandler("path"))
+	headerBackend := newBackendServer(t, textHandler("header"))
+
+	for _, tt := range []struct {
+		order    []string
+		expected string
+	}{
+		{nil, "path"},
+		{[]string{RouteByHeader, RouteByPath}, "header"},
+	} {
+		l := NewLBLight(0)
+		l.RouteOrder = tt.order
+		addRouter(t, l, routerFor(t, pathBackend, "/api"))
+		host, port := hostPort(t, headerBackend)
+		addRouter(t, l, NewBackendRouter(host, port, map[string]string{"X-Env": "canary"}, nil, 10))
+		lb := serveLB(t, l)
+
+		req, _ := http.NewRequest(http.MethodGet, lb.URL+"/api/x", nil)
+		req.Header.Set("X-Env", "canary")
+		if resp, body := doRequest(t, req); resp.StatusCode != http.StatusOK || body != tt.expected {
+			t.Errorf("RouteOrder %v: expected the %s router, got %d %q", tt.order, tt.expected, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestNoBackendAvailableWithoutLazyCreation(t *testing.T) {
+	ber := NewBackendRouter("127.0.0.1", 9000, nil, nil, 5)
+	ber.AllowLazyCreation = false
+
+	if _, err := ber.GetBackend(); !errors.Is(err, ErrNoBackendAvailable) {
+		t.Errorf("expected ErrNoBackendAvailable from an empty pool, got %v", err)
+	}
+
+	be := ber.AddBackend("http://10.0.0.1:8080", nil)
+	got, err := ber.GetBackend()
+	if err != nil || got != be {
+		t.Fatalf("expected the added backend, got %v %v", got, err)
+	}
+	if _, err := ber.GetBackend(); !errors.Is(err, ErrNoBackendAvailable) {
+		t.Errorf("expected ErrNoBackendAvailable with the only backend busy, got %v", err)
+	}
+	if n := len(ber.stats().Backends); n != 1 {
+		t.Errorf("expected the pool to stay at 1 backend, got %d", n)
+	}
+
+	ber.ReleaseBackend(be)
+	if got, err := ber.GetBackend(); err != nil || got != be {
+		t.Errorf("expected the released backend, got %v %v", got, err)
+	}
+}
+
+func TestChunkedStreamsBothWays(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.NewResponseController(w).EnableFullDuplex()
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		// echo each line as soon as it arrives.
+		reader := bufio.NewReader(r.Body)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			io.WriteString(w, "echo "+line)
+			w.(http.Flusher).Flush()
+		}
+	})
+
+	l := NewLBLight(0)
+	addRouter(t, l, routerFor(t, backend, "/"))
+	lb := serveLB(t, l)
+
+	pr, pw := io.Pipe()
+	req, _ := http.NewRequest(http.MethodPost, lb.URL+"/stream", pr)
+	respCh := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			pr.CloseWithError(err)
+			close(respCh)
+			return
+		}
+		respCh <- resp
+	}()
+
+	// the backend flushes its headers straight away, so the response starts with the upload still open.
+	io.WriteString(pw, "chunk 1\n")
+	var resp *http.Response
+	select {
+	case resp = <-respCh:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("response never started")
+	}
+	if resp == nil {
+		t.Fatalf("request failed")
+	}
+	defer resp.Body.Close()
+
+	lines := make(chan string)
+	go func() {
+		reader := bufio.NewReader(resp.Body)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- line
+		}
+	}()
+
+	for i := 1; i <= 3; i++ {
+		if i > 1 {
+			fmt.Fprintf(pw, "chunk %d\n", i)
+		}
+		select {
+		case line := <-lines:
+			if expected := fmt.Sprintf("echo chunk %d\n", i); line != expected {
+				t.Fatalf("expected %q, got %q", expected, line)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("chunk %d never came back while the upload was still open", i)
+		}
+	}
+	pw.Close()
+}
+
+func TestPauseAndResume(t *testing.T) {
+	backend := newBackendServer(t, textHandler("ok"))
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.RetryAfterSeconds = 5
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	ber.Pause()
+	resp, _ := get(t, lb.URL+"/")
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "5" {
+		t.Errorf("expected 503 with Retry-After 5 while paused, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if !l.Stats().Routers[0].Paused {
+		t.Errorf("expected the router to show as paused in the stats")
+	}
+
+	ber.Resume()
+	if resp, body := get(t, lb.URL+"/"); resp.StatusCode != http.StatusOK || body != "ok" {
+		t.Errorf("expected traffic to flow after Resume, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestReplaceBackendsCreatesForNewSet(t *testing.T) {
+	oldBackend := newBackendServer(t, textHandler("old"))
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	newBackend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hold" {
+			started <- struct{}{}
+			<-release
+		}
+		w.Write([]byte("new"))
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, oldBackend, "/")
+	ber.MinBackends = 2
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+	var releaseOnce sync.Once
+	releaseAll := func() { releaseOnce.Do(func() { close(release) }) }
+	t.Cleanup(releaseAll)
+	if _, body := get(t, lb.URL+"/"); body != "old" {
+		t.Fatalf("expected the old backend before the replace, got %q", body)
+	}
+
+	if err := ber.ReplaceBackends([]string{newBackend.URL}, time.Second); err != nil {
+		t.Fatalf("ReplaceBackends failed: %s", err)
+	}
+	// MinBackends is made up from the new set, not host/port.
+	for _, bs := range ber.stats().Backends {
+		if bs.URL != newBackend.URL {
+			t.Errorf("expected every backend to be for the new set, got %s", bs.URL)
+		}
+	}
+
+	// with both busy, a lazily created backend is for the new set too.
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			resp, err := http.Get(lb.URL + "/hold")
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		select {
+		case <-started:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("request %d never reached the new set", i+1)
+		}
+	}
+	if resp, body := get(t, lb.URL+"/"); resp.StatusCode != http.StatusOK || body != "new" {
+		t.Errorf("expected a new backend created for the new set, got %d %q", resp.StatusCode, body)
+	}
+	releaseAll()
+	<-done
+	<-done
+	if n := len(ber.stats().Backends); n != 3 {
+		t.Errorf("expected 3 backends after the lazy creation, got %d", n)
+	}
+}
+
+func TestHTTP10Clients(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stream" {
+			w.Write([]byte("part 1 "))
+			w.(http.Flusher).Flush()
+			w.Write([]byte("part 2"))
+			return
+		}
+		w.Header().Set("Content-Length", "5")
+		w.Write([]byte("hello"))
+	})
+
+	l := NewLBLight(0)
+	addRouter(t, l, routerFor(t, backend, "/"))
+	lb := serveLB(t, l)
+	addr := strings.TrimPrefix(lb.URL, "http://")
+
+	send := func(conn net.Conn, reader *bufio.Reader, path string, header string) (*http.Response, string) {
+		t.Helper()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		fmt.Fprintf(conn, "GET %s HTTP/1.0\r\nHost: lb\r\n%s\r\n", path, header)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("%s: reading response: %s", path, err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("%s: reading body: %s", path, err)
+		}
+		if resp.Proto != "HTTP/1.0" {
+			t.Errorf("%s: expected an HTTP/1.0 response, got %s", path, resp.Proto)
+		}
+		return resp, string(body)
+	}
+	closedAfter := func(reader *bufio.Reader) bool {
+		_, err := reader.ReadByte()
+		return err == io.EOF
+	}
+
+	for _, path := range []string{"/known", "/stream"} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader := bufio.NewReader(conn)
+		resp, body := send(conn, reader, path, "")
+		if expected := map[string]string{"/known": "hello", "/stream": "part 1 part 2"}[path]; body != expected {
+			t.Errorf("%s: expected body %q, got %q", path, expected, body)
+		}
+		if len(resp.TransferEncoding) > 0 {
+			t.Errorf("%s: expected no chunking for HTTP/1.0, got %v", path, resp.TransferEncoding)
+		}
+		if !closedAfter(reader) {
+			t.Errorf("%s: expected the connection closed after the body", path)
+		}
+		conn.Close()
+	}
+
+	// keep-alive is only possible with a known length.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for i := 0; i < 2; i++ {
+		resp, body := send(conn, reader, "/known", "Connection: keep-alive\r\n")
+		if body != "hello" || resp.Header.Get("Connection") != "keep-alive" {
+			t.Errorf("request %d: expected hello with Connection: keep-alive, got %q %q", i+1, body, resp.Header.Get("Connection"))
+		}
+	}
+	resp, _ := send(conn, reader, "/stream", "Connection: keep-alive\r\n")
+	if resp.Header.Get("Connection") == "keep-alive" || !closedAfter(reader) {
+		t.Errorf("expected an unknown length response to close the keep-alive connection")
+	}
+}
+
+func TestFreshBackendAliveWithoutHealthChecks(t *testing.T) {
+	backend := newBackendServer(t, textHandler("ok"))
+
+	l := NewLBLight(0)
+	addRouter(t, l, routerFor(t, backend, "/"))
+	lb := serveLB(t, l)
+
+	if resp, body := get(t, lb.URL+"/"); resp.StatusCode != http.StatusOK || body != "ok" {
+		t.Fatalf("expected the first request to be served by a fresh backend, got %d %q", resp.StatusCode, body)
+	}
+	backends := l.Stats().Routers[0].Backends
+	if len(backends) != 1 || !backends[0].Alive {
+		t.Errorf("expected one backend showing as alive, got %+v", backends)
+	}
+}
+
+func TestMinBackendsReplenished(t *testing.T) {
+	ber := NewBackendRouter("127.0.0.1", 9000, nil, map[string]bool{"/": true}, 5)
+	ber.MinBackends = 2
+	l := NewLBLight(0)
+	addRouter(t, l, ber)
+	t.Cleanup(func() { l.Shutdown(context.Background()) })
+
+	if n := len(ber.stats().Backends); n != 2 {
+		t.Fatalf("expected the pool filled to 2 when registered, got %d", n)
+	}
+
+	removed := ber.backends[0]
+	if err := ber.RemoveBackend(removed); err != nil {
+		t.Fatalf("RemoveBackend failed: %s", err)
+	}
+	backends := ber.stats().Backends
+	if len(backends) != 2 {
+		t.Fatalf("expected the pool topped back up to 2, got %d", len(backends))
+	}
+	for _, bs := range backends {
+		if bs.ID == removed.ID {
+			t.Errorf("removed backend %s still in the pool", removed.ID)
+		}
+	}
+	if err := ber.RemoveBackend(removed); err == nil {
+		t.Errorf("expected an error removing a backend that's already gone")
+	}
+}
+
+func TestRouteFuncWinsOverBuiltInMatching(t *testing.T) {
+	pathBackend := newBackendServer(t, textHandler("path"))
+	oddBackend := newBackendServer(t, textHandler("odd"))
+	evenBackend := newBackendServer(t, textHandler("even"))
+
+	l := NewLBLight(0)
+	addRouter(t, l, routerFor(t, pathBackend, "/"))
+	odd := routerFor(t, oddBackend, "/odd")
+	even := routerFor(t, evenBackend, "/even")
+	addRouter(t, l, odd)
+	addRouter(t, l, even)
+	l.SetRouteFunc(func(req *http.Request) (*BackendRouter, error) {
+		id := req.URL.Query().Get("id")
+		if id == "" {
+			return nil, nil
+		}
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, err
+		}
+		if n%2 == 0 {
+			return even, nil
+		}
+		return odd, nil
+	})
+	lb := serveLB(t, l)
+
+	for _, tt := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/?id=1", http.StatusOK, "odd"},
+		{"/?id=2", http.StatusOK, "even"},
+		// the route func beats the /odd prefix.
+		{"/odd?id=4", http.StatusOK, "even"},
+		{"/?id=x", http.StatusNotFound, ""},
+		// no id, built in matching.
+		{"/", http.StatusOK, "path"},
+	} {
+		if resp, body := get(t, lb.URL+tt.path); resp.StatusCode != tt.status || body != tt.body {
+			t.Errorf("%s: expected %d %q, got %d %q", tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestMaxConcurrentCreationsBoundsBurst(t *testing.T) {
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	t.Cleanup(func() { releaseOnce.Do(func() { close(release) }) })
+	var arrived int32
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&arrived, 1)
+		<-release
+		w.Write([]byte("ok"))
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.MaxConcurrentCreations = 2
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	const burst = 10
+	statuses := make(chan int, burst)
+	for i := 0; i < burst; i++ {
+		go func() {
+			resp, err := http.Get(lb.URL + "/")
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+
+	// everything over the bound is turned away while the first two warm up.
+	busy := 0
+	for i := 0; i < burst-2; i++ {
+		select {
+		case status := <-statuses:
+			if status != http.StatusServiceUnavailable {
+				t.Errorf("expected 503 over the creation bound, got %d", status)
+			}
+			busy++
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d requests turned away, expected %d", busy, burst-2)
+		}
+	}
+	// the 503s can beat the second warming request to the backend.
+	waitFor(t, "both warming requests to reach the backend", func() bool {
+		return atomic.LoadInt32(&arrived) == 2
+	})
+	if n := len(ber.stats().Backends); n != 2 {
+		t.Errorf("expected 2 backends created during the burst, got %d", n)
+	}
+
+	releaseOnce.Do(func() { close(release) })
+	for i := 0; i < 2; i++ {
+		if status := <-statuses; status != http.StatusOK {
+			t.Errorf("expected the warming backends to serve, got %d", status)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package pkg
+
+import (
+	log "github.com/sirupsen/logrus"
+	"net"
+	"time"
+)
+
+// keepAliveConn is the part of *net.TCPConn keepAliveListener needs.
+type keepAliveConn interface {
+	SetKeepAlive(keepalive bool) error
+	SetKeepAlivePeriod(d time.Duration) error
+}
+
+// keepAliveListener sets TCP keep-alive on accepted connections, so idle clients behind NAT
+// (and dead ones) are noticed. A negative period turns keep-alive off.
+type keepAliveListener struct {
+	net.Listener
+	period time.Duration
+}
+
+func (l *keepAliveListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	kac, ok := conn.(keepAliveConn)
+	if !ok {
+		return conn, nil
+	}
+	if l.period < 0 {
+		err = kac.SetKeepAlive(false)
+	} else if err = kac.SetKeepAlive(true); err == nil {
+		err = kac.SetKeepAlivePeriod(l.period)
+	}
+	if err != nil {
+		log.Warnf("Unable to set TCP keep-alive for %s : %s", conn.RemoteAddr(), err.Error())
+	}
+	return conn, nil
+}
+
+// wrapListener applies the listener options (drain, TCP keep-alive, PROXY protocol) to ln.
+func (l *LBLight) wrapListener(ln net.Listener) net.Listener {
+	if l.DrainFile != "" && l.DrainRefuseConnections {
+		ln = &drainListener{Listener: ln, lb: l}
+	}
+	if l.TCPKeepAlive != 0 {
+		ln = &keepAliveListener{Listener: ln, period: l.TCPKeepAlive}
+	}
+	if l.ProxyProtocol {
+		ln = &proxyProtocolListener{Listener: ln}
+	}
+	return ln
+}
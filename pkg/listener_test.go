@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// keepAliveRecorder is a connection recording the keep-alive settings made on it.
+type keepAliveRecorder struct {
+	net.Conn
+	keepAlive bool
+	period    time.Duration
+}
+
+func (kr *keepAliveRecorder) SetKeepAlive(keepalive bool) error {
+	kr.keepAlive = keepalive
+	return nil
+}
+
+func (kr *keepAliveRecorder) SetKeepAlivePeriod(d time.Duration) error {
+	kr.period = d
+	return nil
+}
+
+// oneConnListener accepts conn once.
+type oneConnListener struct {
+	net.Listener
+	conn net.Conn
+}
+
+func (ol *oneConnListener) Accept() (net.Conn, error) {
+	return ol.conn, nil
+}
+
+func TestTCPKeepAliveOnAcceptedConnections(t *testing.T) {
+	for _, tt := range []struct {
+		setting   time.Duration
+		keepAlive bool
+		period    time.Duration
+	}{
+		{15 * time.Second, true, 15 * time.Second},
+		{-1, false, 0},
+	} {
+		l := NewLBLight(0)
+		l.TCPKeepAlive = tt.setting
+		recorder := &keepAliveRecorder{keepAlive: !tt.keepAlive}
+		conn, err := l.wrapListener(&oneConnListener{conn: recorder}).Accept()
+		if err != nil || conn != recorder {
+			t.Fatalf("expected the accepted connection back, got %v %v", conn, err)
+		}
+		if recorder.keepAlive != tt.keepAlive || recorder.period != tt.period {
+			t.Errorf("TCPKeepAlive %s: expected keep-alive %v period %s, got %v %s", tt.setting, tt.keepAlive, tt.period, recorder.keepAlive, recorder.period)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package pkg
+
+import (
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+// allowsMethod checks the method against the routers AcceptedMethods, any method is fine if none are set.
+func (ber *BackendRouter) allowsMethod(method string) bool {
+	if len(ber.AcceptedMethods) == 0 {
+		return true
+	}
+	for _, accepted := range ber.AcceptedMethods {
+		if strings.EqualFold(accepted, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectMethod responds 405 with an Allow header if the router doesn't accept the requests method.
+// Returns true if the request has been dealt with.
+func rejectMethod(ber *BackendRouter, res http.ResponseWriter, req *http.Request) bool {
+	if ber.allowsMethod(req.Method) {
+		return false
+	}
+
+	allowed := make([]string, len(ber.AcceptedMethods))
+	for i, method := range ber.AcceptedMethods {
+		allowed[i] = strings.ToUpper(method)
+	}
+	log.Warnf("Rejecting %s request for URL %s, router %s only accepts %s", req.Method, req.RequestURI, ber.String(), strings.Join(allowed, ", "))
+	res.Header().Set("Allow", strings.Join(allowed, ", "))
+	res.WriteHeader(http.StatusMethodNotAllowed)
+	return true
+}
@@ -0,0 +1,37 @@
+package pkg
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAcceptedMethods(t *testing.T) {
+	var hits int
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write([]byte("ok"))
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.AcceptedMethods = []string{http.MethodGet}
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	resp, err := http.Post(lb.URL+"/", "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET" {
+		t.Errorf("expected 405 with Allow GET, got %d %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+	if hits != 0 {
+		t.Errorf("expected the POST not to reach the backend, got %d hits", hits)
+	}
+
+	if resp, body := get(t, lb.URL+"/"); resp.StatusCode != http.StatusOK || body != "ok" {
+		t.Errorf("expected GET to be proxied, got %d %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// RequestEvent describes a request at a point in its lifecycle. ID is the same for every
+// callback for the request.
+type RequestEvent struct {
+	ID     uint64
+	Method string
+	Path   string
+	Start  time.Time
+
+	// Elapsed is the time since Start when the callback was made.
+	Elapsed time.Duration
+
+	// Router and Backend are set once a backend has been selected.
+	Router  string
+	Backend string
+
+	// StatusCode is the backends status for ResponseReceived and the status sent to the client
+	// for RequestEnded.
+	StatusCode int
+}
+
+// RequestObserver gets callbacks through a requests lifecycle. RequestStarted and RequestEnded
+// are called for every request, BackendSelected and ResponseReceived only for requests that get
+// to a backend (once per attempt when retrying). Callbacks are made on the requests goroutine
+// so should be quick.
+type RequestObserver interface {
+	RequestStarted(ev RequestEvent)
+	BackendSelected(ev RequestEvent)
+	ResponseReceived(ev RequestEvent)
+	RequestEnded(ev RequestEvent)
+}
+
+type observedKey struct{}
+
+// observedRequest is the lifecycle state of a request, kept in its context.
+type observedRequest struct {
+	observer RequestObserver
+	event    RequestEvent
+}
+
+func (o *observedRequest) now() RequestEvent {
+	ev := o.event
+	ev.Elapsed = time.Since(ev.Start)
+	return ev
+}
+
+// observed starts observing the request, returning the request to carry on with and the func
+// to call once it's done.
+func (l *LBLight) observed(res http.ResponseWriter, req *http.Request) (http.ResponseWriter, *http.Request, func()) {
+	o := &observedRequest{observer: l.RequestObserver}
+	o.event = RequestEvent{
+		ID:     atomic.AddUint64(&l.requestIDs, 1),
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Start:  time.Now(),
+	}
+	o.observer.RequestStarted(o.now())
+
+	counted := &countingResponseWriter{ResponseWriter: res}
+	req = req.WithContext(context.WithValue(req.Context(), observedKey{}, o))
+	return counted, req, func() {
+		o.event.StatusCode = counted.status
+		if o.event.StatusCode == 0 {
+			o.event.StatusCode = http.StatusOK
+		}
+		o.observer.RequestEnded(o.now())
+	}
+}
+
+// observeBackendSelected tells the observer (if any) which backend is handling the request.
+func observeBackendSelected(req *http.Request, ber *BackendRouter, be *Backend) {
+	if o, ok := req.Context().Value(observedKey{}).(*observedRequest); ok {
+		o.event.Router = ber.String()
+		o.event.Backend = be.ID
+		o.observer.BackendSelected(o.now())
+	}
+}
+
+// observeResponse tells the observer (if any) the backend has responded.
+func observeResponse(resp *http.Response) {
+	if o, ok := resp.Request.Context().Value(observedKey{}).(*observedRequest); ok {
+		ev := o.now()
+		ev.StatusCode = resp.StatusCode
+		o.observer.ResponseReceived(ev)
+	}
+}
@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// recordingObserver records each callback name and its event.
+type recordingObserver struct {
+	mux    sync.Mutex
+	names  []string
+	events []RequestEvent
+}
+
+func (ro *recordingObserver) record(name string, ev RequestEvent) {
+	ro.mux.Lock()
+	defer ro.mux.Unlock()
+	ro.names = append(ro.names, name)
+	ro.events = append(ro.events, ev)
+}
+
+func (ro *recordingObserver) RequestStarted(ev RequestEvent)   { ro.record("started", ev) }
+func (ro *recordingObserver) BackendSelected(ev RequestEvent)  { ro.record("selected", ev) }
+func (ro *recordingObserver) ResponseReceived(ev RequestEvent) { ro.record("received", ev) }
+func (ro *recordingObserver) RequestEnded(ev RequestEvent)     { ro.record("ended", ev) }
+
+func (ro *recordingObserver) snapshot() ([]string, []RequestEvent) {
+	ro.mux.Lock()
+	defer ro.mux.Unlock()
+	return append([]string{}, ro.names...), append([]RequestEvent{}, ro.events...)
+}
+
+func TestRequestObserverCallbacks(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	l := NewLBLight(0)
+	observer := &recordingObserver{}
+	l.RequestObserver = observer
+	ber := routerFor(t, backend, "/")
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	get(t, lb.URL+"/items")
+	waitFor(t, "RequestEnded", func() bool {
+		names, _ := observer.snapshot()
+		return len(names) == 4
+	})
+
+	names, events := observer.snapshot()
+	expected := []string{"started", "selected", "received", "ended"}
+	for i, name := range expected {
+		if names[i] != name {
+			t.Fatalf("expected callbacks %v, got %v", expected, names)
+		}
+		if events[i].ID != events[0].ID || events[i].Method != http.MethodGet || events[i].Path != "/items" {
+			t.Errorf("%s: expected ID %d for GET /items, got %+v", name, events[0].ID, events[i])
+		}
+	}
+	if be := events[1].Backend; be != backendIDs(ber)[backend.URL] {
+		t.Errorf("expected BackendSelected with the backend ID, got %q", be)
+	}
+	if events[2].StatusCode != http.StatusCreated || events[3].StatusCode != http.StatusCreated {
+		t.Errorf("expected status 201 received and sent, got %d and %d", events[2].StatusCode, events[3].StatusCode)
+	}
+}
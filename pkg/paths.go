@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// normalizePath cleans the (already percent-decoded) request path: resolves . and .. segments
+// and collapses repeated slashes, keeping any trailing slash. Routing on the raw path would let
+// /api/../admin match the /api router while the backend serves /admin.
+// dirty is true if the path had dot segments, or still decodes to some after cleaning (ie was
+// double encoded, eg %252e%252e), which a well behaved client shouldn't send.
+func normalizePath(p string) (cleaned string, dirty bool) {
+	// OPTIONS *
+	if p == "*" {
+		return p, false
+	}
+
+	cleaned = path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	dirty = hasDotSegment(p)
+	if decoded, err := url.PathUnescape(cleaned); err == nil && decoded != cleaned && hasDotSegment(decoded) {
+		dirty = true
+	}
+	return cleaned, dirty
+}
+
+func hasDotSegment(p string) bool {
+	for _, segment := range strings.Split(p, "/") {
+		if segment == "." || segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// pathKey is how a path (prefix) is compared for the router: as is if it has CaseSensitivePaths,
+// otherwise lower cased.
+func (ber *BackendRouter) pathKey(p string) string {
+	if ber.CaseSensitivePaths {
+		return p
+	}
+	return strings.ToLower(p)
+}
+
+// hasPathPrefix checks if path starts with prefix, case insensitively unless the router has CaseSensitivePaths.
+func (ber *BackendRouter) hasPathPrefix(p string, prefix string) bool {
+	return strings.HasPrefix(ber.pathKey(p), ber.pathKey(prefix))
+}
+
+// trailingSlashRedirect returns where to redirect req if its path doesn't route but the same path with
+// (or without) a trailing slash does, eg /api when only /api/ is registered.
+func (l *LBLight) trailingSlashRedirect(req *http.Request) (string, bool) {
+	p := req.URL.Path
+	if p == "/" || p == "" || p == "*" {
+		return "", false
+	}
+
+	if strings.HasSuffix(p, "/") {
+		p = strings.TrimSuffix(p, "/")
+	} else {
+		p += "/"
+	}
+
+	alt := req.Clone(req.Context())
+	alt.URL.Path = p
+	alt.URL.RawPath = ""
+	if _, err := l.routeRequest(alt); err != nil {
+		return "", false
+	}
+
+	location := alt.URL.EscapedPath()
+	if alt.URL.RawQuery != "" {
+		location += "?" + alt.URL.RawQuery
+	}
+	return location, true
+}
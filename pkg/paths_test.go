@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNormalizePath(t *testing.T) {
+	for _, tt := range []struct {
+		path    string
+		cleaned string
+		dirty   bool
+	}{
+		{"/api/x", "/api/x", false},
+		{"/api/x/", "/api/x/", false},
+		{"//api///x", "/api/x", false},
+		{"/api/../admin", "/admin", true},
+		{"/api/./x/", "/api/x/", true},
+		{"/../../etc/passwd", "/etc/passwd", true},
+		// double encoded, net/http has decoded it once.
+		{"/api/%2e%2e/admin", "/api/%2e%2e/admin", true},
+		{"*", "*", false},
+	} {
+		cleaned, dirty := normalizePath(tt.path)
+		if cleaned != tt.cleaned || dirty != tt.dirty {
+			t.Errorf("normalizePath(%q) = %q, %v expected %q, %v", tt.path, cleaned, dirty, tt.cleaned, tt.dirty)
+		}
+	}
+}
+
+func TestPathTraversalDoesNotReachRouter(t *testing.T) {
+	var apiHits int32
+	api := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&apiHits, 1)
+		pathHandler(w, r)
+	})
+	admin := newBackendServer(t, pathHandler)
+
+	for _, reject := range []bool{false, true} {
+		atomic.StoreInt32(&apiHits, 0)
+		l := NewLBLight(0)
+		l.RejectUncleanPaths = reject
+		addRouter(t, l, routerFor(t, api, "/api"))
+		addRouter(t, l, routerFor(t, admin, "/admin"))
+		lb := serveLB(t, l)
+
+		for _, path := range []string{"/api/../admin", "/api/%2e%2e/admin", "/api/%252e%252e/admin"} {
+			resp, body := get(t, lb.URL+path)
+			switch {
+			case reject && resp.StatusCode != http.StatusBadRequest:
+				t.Errorf("RejectUncleanPaths %s: expected 400, got %d %q", path, resp.StatusCode, body)
+			case !reject && path != "/api/%252e%252e/admin" && body != "/admin":
+				t.Errorf("%s: expected the admin router to get /admin, got %d %q", path, resp.StatusCode, body)
+			}
+		}
+		if reject && atomic.LoadInt32(&apiHits) != 0 {
+			t.Errorf("RejectUncleanPaths: the api backend got %d traversal requests", apiHits)
+		}
+	}
+}
+
+func TestRedirectTrailingSlash(t *testing.T) {
+	backend := newBackendServer(t, pathHandler)
+
+	l := NewLBLight(0)
+	l.RedirectTrailingSlash = true
+	addRouter(t, l, routerFor(t, backend, "/api/"))
+	lb := serveLB(t, l)
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	for _, tt := range []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/api?page=2", http.StatusPermanentRedirect, "/api/?page=2"},
+		{"/api/users", http.StatusOK, ""},
+		{"/other", http.StatusNotFound, ""},
+	} {
+		resp, err := client.Get(lb.URL + tt.path)
+		if err != nil {
+			t.Fatalf("%s: request failed: %s", tt.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || resp.Header.Get("Location") != tt.location {
+			t.Errorf("%s: expected %d Location %q, got %d %q", tt.path, tt.status, tt.location, resp.StatusCode, resp.Header.Get("Location"))
+		}
+	}
+}
+
+func TestCaseSensitiveAndInsensitiveRouters(t *testing.T) {
+	sensitiveBackend := newBackendServer(t, textHandler("sensitive"))
+	insensitiveBackend := newBackendServer(t, textHandler("insensitive"))
+
+	l := NewLBLight(0)
+	sensitive := routerFor(t, sensitiveBackend, "/API", "/Docs")
+	sensitive.CaseSensitivePaths = true
+	addRouter(t, l, sensitive)
+	addRouter(t, l, routerFor(t, insensitiveBackend, "/api"))
+	lb := serveLB(t, l)
+
+	for _, tt := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		// both match, the case sensitive router wins.
+		{"/API/users", http.StatusOK, "sensitive"},
+		{"/api/users", http.StatusOK, "insensitive"},
+		{"/Api/users", http.StatusOK, "insensitive"},
+		{"/Docs/intro", http.StatusOK, "sensitive"},
+		{"/docs/intro", http.StatusNotFound, ""},
+	} {
+		if resp, body := get(t, lb.URL+tt.path); resp.StatusCode != tt.status || (tt.body != "" && body != tt.body) {
+			t.Errorf("%s: expected %d %q, got %d %q", tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestProxyProtocolV1ClientIP(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Forwarded-For")))
+	})
+
+	l := NewLBLight(0)
+	l.ProxyProtocol = true
+	addRouter(t, l, routerFor(t, backend, "/"))
+	addr := serveLBServer(t, l)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\n")
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: lb\r\nConnection: close\r\n\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("reading response: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "203.0.113.7" {
+		t.Errorf("expected X-Forwarded-For 203.0.113.7, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestProxyProtocolKeepsReadHeaderTimeout(t *testing.T) {
+	backend := newBackendServer(t, textHandler("ok"))
+
+	l := NewLBLight(0)
+	l.ProxyProtocol = true
+	l.ReadHeaderTimeout = 200 * time.Millisecond
+	addRouter(t, l, routerFor(t, backend, "/"))
+	addr := serveLBServer(t, l)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\n")
+	// then stall part way through the request headers.
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: lb\r\n")
+
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	ioutil.ReadAll(conn)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the stalled connection closed at ReadHeaderTimeout, still open after %s", elapsed)
+	}
+}
+
+func TestProxyProtocolConnKeepsReadDeadline(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := (&proxyProtocolListener{Listener: ln}).Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// as the server does for ReadHeaderTimeout, before the header has been read.
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	io.WriteString(client, "PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\n")
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			t.Errorf("expected a timeout reading past the header, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("expected the read deadline to still apply after the PROXY header")
+	}
+}
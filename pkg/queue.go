@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrQueueFull is returned by GetBackend when every backend is busy and the routers queue
+// already has QueueDepth requests waiting.
+var ErrQueueFull = errors.New("unable to provide backend for request, queue full")
+
+// busyError reports if err means every backend was busy, as opposed to broken.
+func busyError(err error) bool {
+	return errors.Is(err, ErrPoolExhausted) || errors.Is(err, ErrNoBackendAvailable) || errors.Is(err, ErrQueueFull)
+}
+
+// releasedChan returns the channel closed the next time a backend is released (or added).
+// Caller must hold the lock.
+func (ber *BackendRouter) releasedChan() chan struct{} {
+	if ber.released == nil {
+		ber.released = make(chan struct{})
+	}
+	return ber.released
+}
+
+// notifyReleased wakes up any queued requests. Caller must hold the lock.
+func (ber *BackendRouter) notifyReleased() {
+	if ber.released != nil {
+		close(ber.released)
+		ber.released = nil
+	}
+}
+
+// queueForBackend waits (up to QueueTimeout) for a backend to free up. Caller must hold the lock,
+// which is released while waiting.
+func (ber *BackendRouter) queueForBackend(req *http.Request) (*Backend, error) {
+	if ber.QueueDepth > 0 && ber.queued >= ber.QueueDepth {
+		return nil, ErrQueueFull
+	}
+	ber.queued++
+	defer func() { ber.queued-- }()
+
+	timer := time.NewTimer(ber.QueueTimeout)
+	defer timer.Stop()
+
+	var done <-chan struct{}
+	if req != nil {
+		done = req.Context().Done()
+	}
+
+	for {
+		released := ber.releasedChan()
+		ber.mux.Unlock()
+		select {
+		case <-released:
+			ber.mux.Lock()
+		case <-timer.C:
+			ber.mux.Lock()
+			return nil, ErrPoolExhausted
+		case <-done:
+			ber.mux.Lock()
+			return nil, req.Context().Err()
+		}
+
+		// someone else may have got in first, in which case keep waiting.
+		be, err := ber.getBackend(req)
+		if err == nil || !busyError(err) {
+			return be, err
+		}
+	}
+}
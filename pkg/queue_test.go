@@ -0,0 +1,59 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestQueuedRequestSucceedsAndOverflowIsRejected(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+		w.Write([]byte("ok"))
+	})
+
+	l := NewLBLight(0)
+	host, port := hostPort(t, backend)
+	ber := NewBackendRouter(host, port, nil, map[string]bool{"/": true}, 1)
+	ber.QueueTimeout = 5 * time.Second
+	ber.QueueDepth = 1
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	statuses := make(chan int, 2)
+	send := func(path string) {
+		resp, err := http.Get(lb.URL + path)
+		if err != nil {
+			statuses <- 0
+			return
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		statuses <- resp.StatusCode
+	}
+
+	go send("/slow")
+	<-started
+	go send("/queued")
+	waitFor(t, "the request to queue", func() bool {
+		return l.Stats().Routers[0].Queued == 1
+	})
+
+	resp, _ := get(t, lb.URL+"/overflow")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 past QueueDepth, got %d", resp.StatusCode)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if status := <-statuses; status != http.StatusOK {
+			t.Errorf("expected the held and queued requests to succeed, got %d", status)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package pkg
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitKeyFunc returns the key a request is rate limited by, eg an API key.
+type RateLimitKeyFunc func(*http.Request) string
+
+// RateLimitByHeader keys rate limiting by the value of a request header, eg "X-API-Key".
+func RateLimitByHeader(header string) RateLimitKeyFunc {
+	return func(req *http.Request) string {
+		return req.Header.Get(header)
+	}
+}
+
+// RateLimitConfig limits how fast a single client (by IP, or KeyFunc) can send requests to a router,
+// with a token bucket per client. Clients over the limit get a 429 Too Many Requests.
+type RateLimitConfig struct {
+	// Rate is the requests per second each client is allowed on average.
+	Rate float64
+
+	// Burst is how many requests a client can make at once before being limited. Default 1.
+	Burst int
+
+	// MaxClients is how many clients are tracked, beyond which the least recently seen are
+	// forgotten (so start again with a full bucket). Default 10000.
+	MaxClients int
+
+	// KeyFunc identifies the client for a request, each key getting its own bucket. Requests it
+	// returns "" for fall back to the client IP. nil (default) limits by client IP.
+	KeyFunc RateLimitKeyFunc
+}
+
+// key is the bucket a request is counted against.
+func (rc *RateLimitConfig) key(req *http.Request, useForwardedFor bool) string {
+	if rc.KeyFunc != nil {
+		if key := rc.KeyFunc(req); key != "" {
+			return "key:" + key
+		}
+	}
+	return clientIP(req, useForwardedFor)
+}
+
+func (rc *RateLimitConfig) burst() float64 {
+	if rc.Burst <= 0 {
+		return 1
+	}
+	return float64(rc.Burst)
+}
+
+func (rc *RateLimitConfig) maxClients() int {
+	if rc.MaxClients <= 0 {
+		return 10000
+	}
+	return rc.MaxClients
+}
+
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter holds a token bucket per client, evicting the least recently used.
+type rateLimiter struct {
+	config RateLimitConfig
+
+	mux     sync.Mutex
+	buckets map[string]*list.Element
+	lru     *list.List
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	return &rateLimiter{config: config, buckets: make(map[string]*list.Element), lru: list.New()}
+}
+
+// allow takes a token from keys bucket. If there isn't one, returns false and how long until there is.
+func (rl *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	rl.mux.Lock()
+	defer rl.mux.Unlock()
+
+	var bucket *tokenBucket
+	if el, ok := rl.buckets[key]; ok {
+		rl.lru.MoveToFront(el)
+		bucket = el.Value.(*tokenBucket)
+		bucket.tokens = math.Min(rl.config.burst(), bucket.tokens+now.Sub(bucket.last).Seconds()*rl.config.Rate)
+		bucket.last = now
+	} else {
+		bucket = &tokenBucket{key: key, tokens: rl.config.burst(), last: now}
+		rl.buckets[key] = rl.lru.PushFront(bucket)
+		for rl.lru.Len() > rl.config.maxClients() {
+			oldest := rl.lru.Back()
+			rl.lru.Remove(oldest)
+			delete(rl.buckets, oldest.Value.(*tokenBucket).key)
+		}
+	}
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	if rl.config.Rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	return false, time.Duration((1 - bucket.tokens) / rl.config.Rate * float64(time.Second))
+}
+
+// rateLimited checks the request against the routers ClientRateLimit, writing the 429 if it's over.
+// Returns true if the request was limited.
+func (ber *BackendRouter) rateLimited(res http.ResponseWriter, req *http.Request) bool {
+	if ber.ClientRateLimit == nil {
+		return false
+	}
+	ber.rateLimiterOnce.Do(func() {
+		ber.rateLimiter = newRateLimiter(*ber.ClientRateLimit)
+	})
+
+	ok, wait := ber.rateLimiter.allow(ber.ClientRateLimit.key(req, ber.UseForwardedFor), time.Now())
+	if ok {
+		return false
+	}
+
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 || wait == time.Duration(math.MaxInt64) {
+		retryAfter = 1
+	}
+	res.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	res.WriteHeader(http.StatusTooManyRequests)
+	return true
+}
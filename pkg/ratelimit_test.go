@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"net/http"
+	"testing"
+)
+
+// sendAs sends a GET through the LB with the header set, returning the status.
+func sendAs(t *testing.T, uri string, header string, value string) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, uri, nil)
+	req.Header.Set(header, value)
+	resp, _ := doRequest(t, req)
+	return resp.StatusCode
+}
+
+func TestClientRateLimitPerIP(t *testing.T) {
+	backend := newBackendServer(t, textHandler("ok"))
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.UseForwardedFor = true
+	ber.ClientRateLimit = &RateLimitConfig{Rate: 0.1, Burst: 3}
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	for i := 0; i < 3; i++ {
+		if status := sendAs(t, lb.URL+"/", "X-Forwarded-For", "203.0.113.1"); status != http.StatusOK {
+			t.Fatalf("request %d within the burst: expected 200, got %d", i+1, status)
+		}
+	}
+	req, _ := http.NewRequest(http.MethodGet, lb.URL+"/", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+	resp, _ := doRequest(t, req)
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("expected the flooding IP to get 429 with Retry-After, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	if status := sendAs(t, lb.URL+"/", "X-Forwarded-For", "203.0.113.2"); status != http.StatusOK {
+		t.Errorf("expected another IP to be unaffected, got %d", status)
+	}
+}
+
+func TestRateLimitByHeader(t *testing.T) {
+	backend := newBackendServer(t, textHandler("ok"))
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.ClientRateLimit = &RateLimitConfig{Rate: 0.1, Burst: 2, KeyFunc: RateLimitByHeader("X-API-Key")}
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	// every request comes from the same IP, only the key tells them apart.
+	for i := 0; i < 2; i++ {
+		if status := sendAs(t, lb.URL+"/", "X-API-Key", "key-a"); status != http.StatusOK {
+			t.Fatalf("key-a request %d within the burst: expected 200, got %d", i+1, status)
+		}
+	}
+	if status := sendAs(t, lb.URL+"/", "X-API-Key", "key-a"); status != http.StatusTooManyRequests {
+		t.Errorf("expected key-a over its burst to get 429, got %d", status)
+	}
+	for i := 0; i < 2; i++ {
+		if status := sendAs(t, lb.URL+"/", "X-API-Key", "key-b"); status != http.StatusOK {
+			t.Errorf("key-b request %d: expected its own bucket, got %d", i+1, status)
+		}
+	}
+	if status := sendAs(t, lb.URL+"/", "X-API-Key", "key-b"); status != http.StatusTooManyRequests {
+		t.Errorf("expected key-b over its burst to get 429, got %d", status)
+	}
+}
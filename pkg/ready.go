@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"context"
+	"time"
+)
+
+// how often WaitForReady checks the routers.
+const readyPollInterval = 50 * time.Millisecond
+
+// ready reports if the router can serve traffic, ie has enough healthy backends (see ReadyMinBackends
+// and ReadyMinPercent) or, with none, can make one on demand.
+func (ber *BackendRouter) ready() bool {
+	ber.mux.Lock()
+	defer ber.mux.Unlock()
+
+	// backends made on demand are only used straight away if they aren't health checked.
+	if len(ber.backends) == 0 {
+		return ber.HealthCheck == nil && ber.canCreateBackend()
+	}
+
+	healthy := 0
+	for _, be := range ber.backends {
+		if !be.healthChecked || be.Alive {
+			healthy++
+		}
+	}
+
+	minBackends := ber.ReadyMinBackends
+	if minBackends <= 0 {
+		minBackends = 1
+	}
+	return healthy >= minBackends && healthy*100 >= ber.ReadyMinPercent*len(ber.backends)
+}
+
+// Ready reports if every registered router has enough healthy backends, by default at least one.
+// Never ready while draining (see DrainFile).
+func (l *LBLight) Ready() bool {
+	if l.Draining() {
+		return false
+	}
+
+	l.mux.RLock()
+	routers := append([]*BackendRouter{}, l.routers...)
+	l.mux.RUnlock()
+
+	for _, ber := range routers {
+		if !ber.ready() {
+			return false
+		}
+	}
+	return true
+}
+
+// WaitForReady blocks until every router has enough healthy backends (see Ready), or ctx
+// expires. Used at startup so the LB doesn't 503 while backends are still coming up.
+// Health checked routers need backends to probe, so add them (AddBackend, WarmPool or a Resolver) first.
+func (l *LBLight) WaitForReady(ctx context.Context) error {
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+
+	for !l.Ready() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
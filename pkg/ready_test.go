@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitForReady(t *testing.T) {
+	var healthy int32
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.AllowLazyCreation = false
+	ber.HealthCheck = &HealthCheckConfig{Path: "/healthz", Interval: 20 * time.Millisecond}
+	addRouter(t, l, ber)
+	ber.AddBackend(backend.URL, nil)
+	t.Cleanup(func() { l.Shutdown(context.Background()) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := l.WaitForReady(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected WaitForReady to give up with the backend unhealthy, got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- l.WaitForReady(context.Background())
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("WaitForReady returned %v before the backend was healthy", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected WaitForReady to return nil, got %s", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("WaitForReady didn't return once the backend was healthy")
+	}
+}
+
+func TestReadyMinPercent(t *testing.T) {
+	healthy := newBackendServer(t, textHandler("ok"))
+	unhealthy := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	for _, tt := range []struct {
+		percent int
+		status  int
+	}{
+		{50, http.StatusServiceUnavailable},
+		{30, http.StatusOK},
+	} {
+		l := NewLBLight(0)
+		ber := NewBackendRouter("127.0.0.1", 9000, nil, map[string]bool{"/": true}, 3)
+		ber.AllowLazyCreation = false
+		ber.HealthCheck = &HealthCheckConfig{Path: "/healthz", Interval: time.Minute}
+		ber.ReadyMinPercent = tt.percent
+		addRouter(t, l, ber)
+		backends := []*Backend{
+			ber.AddBackend(healthy.URL, nil),
+			ber.AddBackend(unhealthy.URL, nil),
+			ber.AddBackend(unhealthy.URL, nil),
+		}
+		for _, be := range backends {
+			<-be.firstProbeDone
+		}
+
+		admin := httptest.NewServer(l.AdminHandler())
+		resp, _ := get(t, admin.URL+"/readyz")
+		if resp.StatusCode != tt.status {
+			t.Errorf("ReadyMinPercent %d with 1 of 3 healthy: expected %d, got %d", tt.percent, tt.status, resp.StatusCode)
+		}
+		admin.Close()
+		l.Shutdown(context.Background())
+	}
+}
+
+func TestDrainFileFailsReadiness(t *testing.T) {
+	backend := newBackendServer(t, textHandler("ok"))
+
+	l := NewLBLight(0)
+	l.DrainFile = filepath.Join(t.TempDir(), "drain")
+	addRouter(t, l, routerFor(t, backend, "/"))
+	t.Cleanup(func() { l.Shutdown(context.Background()) })
+	admin := httptest.NewServer(l.AdminHandler())
+	defer admin.Close()
+
+	if resp, _ := get(t, admin.URL+"/readyz"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 before draining, got %d", resp.StatusCode)
+	}
+
+	if err := ioutil.WriteFile(l.DrainFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := get(t, admin.URL+"/readyz"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while the drain file exists, got %d", resp.StatusCode)
+	}
+
+	os.Remove(l.DrainFile)
+	if resp, _ := get(t, admin.URL+"/readyz"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 once the drain file is removed, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Resolver discovers the backends for a router (eg from Consul, etcd or k8s endpoints).
+// Resolve returns the backend URIs, eg "http://10.0.0.1:8080". Entries without a scheme
+// are assumed to be http.
+type Resolver interface {
+	Resolve() ([]string, error)
+}
+
+func (ber *BackendRouter) resolveInterval() time.Duration {
+	if ber.ResolveInterval <= 0 {
+		return 30 * time.Second
+	}
+	return ber.ResolveInterval
+}
+
+// runResolver refreshes the backends from the resolver every interval until the router is closed.
+func (ber *BackendRouter) runResolver() {
+	ticker := time.NewTicker(ber.resolveInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ber.done:
+			return
+		case <-ticker.C:
+			ber.refreshBackends()
+		}
+	}
+}
+
+// refreshBackends makes the backend list match what the resolver returns. Backends that
+// are still resolved are kept (along with their health/breaker state), new ones are created
+// and missing ones removed. Removed backends that are mid request finish that request but
+// aren't handed out again. If the resolver errors the current list is left alone.
+func (ber *BackendRouter) refreshBackends() {
+	uris, err := ber.Resolver.Resolve()
+	if err != nil {
+		log.Warnf("Unable to resolve backends for router %s : %s", ber.String(), err.Error())
+		return
+	}
+
+	wanted := make(map[string]bool)
+	ordered := []string{}
+	for _, uri := range uris {
+		if !strings.Contains(uri, "://") {
+			uri = "http://" + uri
+		}
+		u, err := url.Parse(uri)
+		if err != nil {
+			log.Warnf("Ignoring invalid backend %s for router %s : %s", uri, ber.String(), err.Error())
+			continue
+		}
+		if !wanted[u.String()] {
+			wanted[u.String()] = true
+			ordered = append(ordered, u.String())
+		}
+	}
+
+	ber.mux.Lock()
+	defer ber.mux.Unlock()
+
+	kept := []*Backend{}
+	existing := make(map[string]bool)
+	for _, be := range ber.backends {
+		uri := be.url.String()
+		if wanted[uri] && !existing[uri] {
+			kept = append(kept, be)
+			existing[uri] = true
+			continue
+		}
+		log.Infof("Removing backend %s (%s) from router %s", be.ID, uri, ber.String())
+		close(be.stopped)
+	}
+
+	for _, uri := range ordered {
+		if existing[uri] {
+			continue
+		}
+		be := ber.newBackend(uri)
+		log.Infof("Adding backend %s (%s) to router %s", be.ID, uri, ber.String())
+		kept = append(kept, be)
+	}
+
+	ber.backends = kept
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubResolver returns whatever backends it's been set to.
+type stubResolver struct {
+	mux  sync.Mutex
+	uris []string
+}
+
+func (sr *stubResolver) set(uris ...string) {
+	sr.mux.Lock()
+	defer sr.mux.Unlock()
+	sr.uris = uris
+}
+
+func (sr *stubResolver) Resolve() ([]string, error) {
+	sr.mux.Lock()
+	defer sr.mux.Unlock()
+	return append([]string{}, sr.uris...), nil
+}
+
+// backendIDs returns the routers backends as URL -> ID.
+func backendIDs(ber *BackendRouter) map[string]string {
+	ids := make(map[string]string)
+	for _, bs := range ber.stats().Backends {
+		ids[bs.URL] = bs.ID
+	}
+	return ids
+}
+
+func TestResolverChangesTrackedByPool(t *testing.T) {
+	resolver := &stubResolver{}
+	resolver.set("10.0.0.1:8080", "http://10.0.0.2:8080")
+
+	l := NewLBLight(0)
+	ber := NewBackendRouter("app", 8080, nil, map[string]bool{"/": true}, 10)
+	ber.Resolver = resolver
+	ber.ResolveInterval = 10 * time.Millisecond
+	addRouter(t, l, ber)
+	defer ber.Close()
+
+	before := backendIDs(ber)
+	if len(before) != 2 || before["http://10.0.0.1:8080"] == "" || before["http://10.0.0.2:8080"] == "" {
+		t.Fatalf("expected both resolved backends straight away, got %v", before)
+	}
+
+	resolver.set("http://10.0.0.2:8080", "http://10.0.0.3:8080")
+	deadline := time.Now().Add(5 * time.Second)
+	var after map[string]string
+	for {
+		after = backendIDs(ber)
+		if _, ok := after["http://10.0.0.3:8080"]; ok && len(after) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pool never caught up with the resolver, got %v", after)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, ok := after["http://10.0.0.1:8080"]; ok {
+		t.Error("backend dropped by the resolver still in the pool")
+	}
+	if after["http://10.0.0.2:8080"] != before["http://10.0.0.2:8080"] {
+		t.Error("backend still resolved was recreated instead of kept")
+	}
+}
@@ -0,0 +1,52 @@
+package pkg
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// multiReadCloser reads the buffered start of a body followed by the rest of it.
+type multiReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// bufferSmallResponse reads responses of up to limit bytes into memory so they're written to the
+// client in one go, with a Content-Length. Anything bigger (or of unknown length that turns out
+// bigger) carries on streaming from where the read stopped. Streams (Server-Sent Events), responses
+// with trailers and ones without a body are left alone.
+func bufferSmallResponse(resp *http.Response, limit int64) error {
+	if resp.Request.Method == http.MethodHead || !bodyAllowed(resp.StatusCode) || len(resp.Trailer) > 0 {
+		return nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "text/event-stream" {
+		return nil
+	}
+	if resp.ContentLength > limit {
+		return nil
+	}
+
+	// one more than the limit tells us if there's more to come.
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		resp.Body.Close()
+		return err
+	}
+
+	if int64(len(body)) > limit {
+		resp.Body = &multiReadCloser{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+		return nil
+	}
+
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.TransferEncoding = nil
+	resp.Header.Del("Transfer-Encoding")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeCountingRecorder counts the Write calls made on it.
+type writeCountingRecorder struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (w *writeCountingRecorder) Write(p []byte) (int, error) {
+	w.writes++
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestBufferResponsesUnder(t *testing.T) {
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+		if r.URL.Path == "/small" {
+			// dribbled out in pieces, but small enough to buffer.
+			for _, piece := range []string{"hello", " ", "world"} {
+				w.Write([]byte(piece))
+				flusher.Flush()
+				time.Sleep(10 * time.Millisecond)
+			}
+			return
+		}
+		w.Write([]byte(strings.Repeat("x", 2048)))
+		flusher.Flush()
+		<-release
+		w.Write([]byte("end"))
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.BufferResponsesUnder = 1024
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+	t.Cleanup(func() { releaseOnce.Do(func() { close(release) }) })
+
+	res := &writeCountingRecorder{ResponseRecorder: httptest.NewRecorder()}
+	l.handleRequestsAndRedirect(res, httptest.NewRequest(http.MethodGet, "/small", nil))
+	if res.writes != 1 || res.Body.String() != "hello world" || res.Header().Get("Content-Length") != "11" {
+		t.Errorf("expected the small response in one write with Content-Length 11, got %d writes %q %q",
+			res.writes, res.Body.String(), res.Header().Get("Content-Length"))
+	}
+
+	// the large one reaches the client while the backend is still sending.
+	resp, err := http.Get(lb.URL + "/large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	read := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(resp.Body, make([]byte, 2048))
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		if err != nil {
+			t.Fatalf("reading the start of the large response: %s", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the large response to stream, nothing arrived before the backend finished")
+	}
+	releaseOnce.Do(func() { close(release) })
+	if rest, _ := ioutil.ReadAll(resp.Body); string(rest) != "end" {
+		t.Errorf("expected the rest of the large response, got %q", rest)
+	}
+}
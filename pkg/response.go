@@ -0,0 +1,161 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// modifyResponse returns the ReverseProxy ModifyResponse hook for a backend.
+// 5xx responses count as failures for the breaker, and the body size is recorded once
+// it's been copied to the client. Also applies the routers StripResponseHops, StatusRewrite, BufferResponsesUnder and adds X-Served-By.
+func (ber *BackendRouter) modifyResponse(be *Backend) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		observeResponse(resp)
+		if be.breaker != nil {
+			if resp.StatusCode >= 500 {
+				be.breaker.recordFailure()
+			} else {
+				be.breaker.recordSuccess()
+			}
+		}
+		be.observeResult(resp.StatusCode < 500)
+
+		// strip before adding the LBs own headers, so they're never dropped.
+		stripHeaders(resp.Header, ber.StripResponseHops)
+		ber.setStickyCookie(be, resp)
+		if current := attemptFromContext(resp.Request.Context()); current != nil {
+			if current.servedBy {
+				resp.Header.Set("X-Served-By", "lblight/"+be.ID)
+			}
+			setResponseTime(resp, current.received)
+		}
+
+		// rewrite after the breaker etc have seen the real status.
+		if code, ok := ber.StatusRewrite[resp.StatusCode]; ok {
+			resp.StatusCode = code
+			resp.Status = fmt.Sprintf("%d %s", code, http.StatusText(code))
+
+			// some codes can't have a body, drop it (and its framing) rather than send a broken response.
+			if !bodyAllowed(code) {
+				resp.Body.Close()
+				resp.Body = http.NoBody
+				resp.ContentLength = 0
+				resp.Header.Del("Content-Length")
+				resp.Header.Del("Transfer-Encoding")
+			}
+		}
+
+		// a failed read here is before anything's been sent, so can still be a 502 (or retried).
+		if ber.BufferResponsesUnder > 0 {
+			if err := bufferSmallResponse(resp, ber.BufferResponsesUnder); err != nil {
+				return err
+			}
+		}
+
+		req := resp.Request
+		resp.Body = &countingReadCloser{ReadCloser: resp.Body, onClose: func(n int64, err error) {
+			// the client has already had the headers, so all that can be done is log it.
+			if err != nil {
+				log.Errorf("Response from backend %s for %s cut off after %d bytes : %s", be.url.String(), req.URL.Path, n, err.Error())
+				if be.breaker != nil {
+					be.breaker.recordFailure()
+				}
+				be.observeResult(false)
+			}
+			// HEAD responses never have a body, counting them would drag the sizes down.
+			if req.Method == http.MethodHead {
+				return
+			}
+			be.responseSizes.observe(n)
+			if ber.LargeResponseThreshold > 0 && n > ber.LargeResponseThreshold {
+				log.Warnf("Large response from backend %s for %s : %d bytes", be.ID, req.URL.Path, n)
+			}
+		}}
+		return nil
+	}
+}
+
+// countingReadCloser counts the bytes read through it and reports the total on Close, along with
+// the read error if the body didn't end cleanly.
+type countingReadCloser struct {
+	io.ReadCloser
+	n       int64
+	err     error
+	onClose func(n int64, err error)
+	closed  bool
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+func (c *countingReadCloser) Close() error {
+	if !c.closed {
+		c.closed = true
+		c.onClose(c.n, c.err)
+	}
+	return c.ReadCloser.Close()
+}
+
+// StaticResponse is a canned response the LB serves itself instead of proxying, eg a maintenance page.
+type StaticResponse struct {
+	// StatusCode defaults to 503 Service Unavailable.
+	StatusCode int
+
+	// Header is copied onto the response, eg Content-Type.
+	Header http.Header
+
+	Body []byte
+}
+
+func (sr *StaticResponse) write(res http.ResponseWriter) {
+	for key, values := range sr.Header {
+		for _, value := range values {
+			res.Header().Add(key, value)
+		}
+	}
+
+	statusCode := sr.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	// the body is known, so its length is too, whatever Header says.
+	res.Header().Del("Transfer-Encoding")
+	if bodyAllowed(statusCode) {
+		res.Header().Set("Content-Length", strconv.Itoa(len(sr.Body)))
+	} else {
+		res.Header().Del("Content-Length")
+		res.WriteHeader(statusCode)
+		return
+	}
+	res.WriteHeader(statusCode)
+	if _, err := res.Write(sr.Body); err != nil {
+		log.Errorf("Unable to write static response %s", err.Error())
+	}
+}
+
+// malformedResponse reports if err from the transport means the backend sent a broken response
+// (cut off or not valid HTTP), rather than not being reachable.
+func malformedResponse(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	var protocolErr *http.ProtocolError
+	return errors.As(err, &protocolErr) || strings.Contains(err.Error(), "malformed HTTP")
+}
+
+// bodyAllowed reports if a response with status code can have a body.
+func bodyAllowed(code int) bool {
+	return code >= 200 && code != http.StatusNoContent && code != http.StatusNotModified
+}
@@ -0,0 +1,271 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrailersForwarded(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Write([]byte("body"))
+		w.Header().Set("X-Checksum", "abc123")
+	})
+
+	l := NewLBLight(0)
+	addRouter(t, l, routerFor(t, backend, "/"))
+	lb := serveLB(t, l)
+
+	resp, err := http.Get(lb.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, ok := resp.Trailer["X-Checksum"]; !ok {
+		t.Errorf("trailer not declared to the client, got Trailer %v", resp.Trailer)
+	}
+
+	// trailer values only arrive once the body has been read.
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "body" {
+		t.Errorf("expected body, got %q", body)
+	}
+	if got := resp.Trailer.Get("X-Checksum"); got != "abc123" {
+		t.Errorf("expected trailer X-Checksum abc123, got %q", got)
+	}
+}
+
+func TestServerSentEventsStream(t *testing.T) {
+	next := make(chan struct{})
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 1; i <= 3; i++ {
+			fmt.Fprintf(w, "data: event %d\n\n", i)
+			w.(http.Flusher).Flush()
+			// the next event is only sent once the client has this one.
+			select {
+			case <-next:
+			case <-time.After(5 * time.Second):
+				return
+			}
+		}
+	})
+
+	l := NewLBLight(0)
+	addRouter(t, l, routerFor(t, backend, "/"))
+	lb := serveLB(t, l)
+
+	resp, err := http.Get(lb.URL + "/events")
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	for i := 1; i <= 3; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("event %d: read failed: %s", i, err)
+		}
+		if expected := fmt.Sprintf("data: event %d\n", i); line != expected {
+			t.Fatalf("expected %q, got %q", expected, line)
+		}
+		reader.ReadString('\n')
+		next <- struct{}{}
+	}
+}
+
+func TestStatusRewrite(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/teapot" {
+			w.WriteHeader(http.StatusTeapot)
+			w.Write([]byte("short and stout"))
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.StatusRewrite = map[int]int{http.StatusTeapot: http.StatusBadRequest}
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	resp, body := get(t, lb.URL+"/teapot")
+	if resp.StatusCode != http.StatusBadRequest || body != "short and stout" {
+		t.Errorf("expected the 418 rewritten to 400 with its body, got %d %q", resp.StatusCode, body)
+	}
+	if resp, _ := get(t, lb.URL+"/"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected other statuses untouched, got %d", resp.StatusCode)
+	}
+}
+
+func TestBackendClosingMidResponse(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack failed: %s", err)
+			return
+		}
+		defer conn.Close()
+		if r.URL.Path == "/headers" {
+			buf.WriteString("HTTP/1.1 200 OK\r\nContent-Le")
+		} else {
+			buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\nonly ten b")
+		}
+		buf.Flush()
+	})
+
+	l := NewLBLight(0)
+	addRouter(t, l, routerFor(t, backend, "/"))
+	lb := serveLB(t, l)
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	// cut off in the headers, nothing sent to the client yet so it's a 502.
+	resp, err := client.Get(lb.URL + "/headers")
+	if err != nil {
+		t.Fatalf("expected a 502, got %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected 502 for a backend closing mid headers, got %d", resp.StatusCode)
+	}
+
+	// cut off in the body, the status has gone out (it may not have reached the client yet) so all
+	// that can be done is abort the connection.
+	resp, err = client.Get(lb.URL + "/body")
+	if err == nil {
+		var body []byte
+		body, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil {
+			t.Errorf("expected the client connection aborted, got %d %q", resp.StatusCode, body)
+		}
+	}
+	if os.IsTimeout(err) {
+		t.Errorf("client hung until its timeout: %s", err)
+	}
+}
+
+func TestFramingAfterBodyRewrites(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/created" {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("a body that a 204 can't have"))
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.StatusRewrite = map[int]int{http.StatusCreated: http.StatusNoContent}
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	// both on one connection, a body left over from the 204 would corrupt the second response.
+	conn, err := net.Dial("tcp", strings.TrimPrefix(lb.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for _, tt := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/created", http.StatusNoContent, ""},
+		{"/", http.StatusOK, "ok"},
+	} {
+		fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: lb\r\n\r\n", tt.path)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("%s: reading response: %s", tt.path, err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != tt.status || string(body) != tt.body {
+			t.Errorf("%s: expected %d %q, got %d %q %v", tt.path, tt.status, tt.body, resp.StatusCode, body, err)
+		}
+	}
+
+	// a static response with a wrong Content-Length gets the real one.
+	res := httptest.NewRecorder()
+	sr := &StaticResponse{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Content-Length": {"999"}}, Body: []byte("down")}
+	sr.write(res)
+	if cl := res.Header().Get("Content-Length"); cl != "4" {
+		t.Errorf("expected the static response Content-Length 4, got %q", cl)
+	}
+}
+
+func TestServedByHeader(t *testing.T) {
+	backend := newBackendServer(t, textHandler("ok"))
+
+	for _, onLB := range []bool{false, true} {
+		l := NewLBLight(0)
+		ber := routerFor(t, backend, "/")
+		if onLB {
+			l.AddServedBy = true
+		} else {
+			ber.AddServedBy = true
+		}
+		addRouter(t, l, ber)
+		lb := serveLB(t, l)
+
+		resp, _ := get(t, lb.URL+"/")
+		if expected := "lblight/" + backendIDs(ber)[backend.URL]; resp.Header.Get("X-Served-By") != expected {
+			t.Errorf("expected X-Served-By %q, got %q", expected, resp.Header.Get("X-Served-By"))
+		}
+	}
+}
+
+func TestHeadRequest(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "11")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Custom", "kept")
+		w.Write([]byte("hello world"))
+	})
+
+	l := NewLBLight(0)
+	addRouter(t, l, routerFor(t, backend, "/"))
+	lb := serveLB(t, l)
+
+	// the GET after it on the same connection would be corrupted by any body sent with the HEAD.
+	conn, err := net.Dial("tcp", strings.TrimPrefix(lb.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	fmt.Fprintf(conn, "HEAD / HTTP/1.1\r\nHost: lb\r\n\r\n")
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodHead})
+	if err != nil {
+		t.Fatalf("reading HEAD response: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != 11 || resp.Header.Get("Content-Type") != "text/plain" || resp.Header.Get("X-Custom") != "kept" {
+		t.Errorf("expected 200 with the GETs headers and Content-Length 11, got %d %d %v", resp.StatusCode, resp.ContentLength, resp.Header)
+	}
+
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: lb\r\n\r\n")
+	resp, err = http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("reading GET response: %s", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || string(body) != "hello world" {
+		t.Errorf("expected 200 hello world after the HEAD, got %d %q %v", resp.StatusCode, body, err)
+	}
+}
@@ -0,0 +1,30 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type receivedKey struct{}
+
+// withReceived records when the LB received the request, for X-Response-Time.
+func withReceived(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), receivedKey{}, time.Now()))
+}
+
+func receivedAt(req *http.Request) time.Time {
+	received, _ := req.Context().Value(receivedKey{}).(time.Time)
+	return received
+}
+
+// setResponseTime adds X-Response-Time, the milliseconds from the LB receiving the request to the
+// backends response starting. Called from ModifyResponse, before anything is sent to the client.
+func setResponseTime(resp *http.Response, received time.Time) {
+	if received.IsZero() {
+		return
+	}
+	ms := float64(time.Since(received)) / float64(time.Millisecond)
+	resp.Header.Set("X-Response-Time", strconv.FormatFloat(ms, 'f', 2, 64))
+}
@@ -0,0 +1,34 @@
+package pkg
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestResponseTimeHeader(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("ok"))
+	})
+
+	l := NewLBLight(0)
+	ber := routerFor(t, backend, "/")
+	ber.AddResponseTime = true
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	start := time.Now()
+	resp, _ := get(t, lb.URL+"/")
+	elapsed := time.Since(start)
+
+	ms, err := strconv.ParseFloat(resp.Header.Get("X-Response-Time"), 64)
+	if err != nil {
+		t.Fatalf("expected X-Response-Time in ms, got %q", resp.Header.Get("X-Response-Time"))
+	}
+	// at least the backends 50ms, and no more than the client saw.
+	if ms < 50 || ms > float64(elapsed.Milliseconds())+1 {
+		t.Errorf("expected X-Response-Time between 50 and %dms, got %.2f", elapsed.Milliseconds(), ms)
+	}
+}
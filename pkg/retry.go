@@ -0,0 +1,204 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// FailurePolicy bundles how a BackendRouter deals with failing backends.
+// Outlier ejection is handled by the circuit breaker: a backend that keeps failing is
+// taken out of rotation for CircuitBreaker.OpenDuration.
+type FailurePolicy struct {
+	// Retries is how many more backends to try after a proxy error (connection refused, reset etc).
+	// Only requests that can be replayed are retried, ie without a body or with BufferRequestBody set.
+	Retries int
+
+	// RetryBackoff is the wait before the first retry, doubling for each one after up to MaxRetryBackoff.
+	// 0 retries straight away.
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+
+	// CircuitBreaker configures a circuit breaker per backend. nil disables circuit breaking.
+	CircuitBreaker *CircuitBreakerConfig
+}
+
+// backoff returns how long to wait before retry number attempt (0 based).
+func (fp *FailurePolicy) backoff(attempt int) time.Duration {
+	backoff := fp.RetryBackoff
+	for i := 0; i < attempt && backoff > 0; i++ {
+		backoff *= 2
+		if fp.MaxRetryBackoff > 0 && backoff >= fp.MaxRetryBackoff {
+			return fp.MaxRetryBackoff
+		}
+	}
+	if fp.MaxRetryBackoff > 0 && backoff > fp.MaxRetryBackoff {
+		return fp.MaxRetryBackoff
+	}
+	return backoff
+}
+
+type attemptKey struct{}
+
+// attempt is stashed in the request context so the proxy ErrorHandler knows if it should
+// write the error to the client, or leave it to be retried.
+type attempt struct {
+	retryable bool
+	err       error
+
+	// every backend tried for the request and why it failed, eg "127.0.0.1:9000/0: connection refused".
+	failures []string
+
+	// include the failures in the 502 body.
+	debug bool
+
+	// add X-Served-By to the response.
+	servedBy bool
+
+	// when the request was received, for X-Response-Time. Zero if not wanted.
+	received time.Time
+
+	// set (atomically, from the admin API) when the request is cancelled with CancelInflight.
+	cancelled int32
+}
+
+func (a *attempt) wasCancelled() bool {
+	return atomic.LoadInt32(&a.cancelled) == 1
+}
+
+func (a *attempt) recordFailure(be *Backend, err error) {
+	a.failures = append(a.failures, fmt.Sprintf("%s: %s", be.ID, err.Error()))
+}
+
+// detail lists every failed attempt.
+func (a *attempt) detail() string {
+	return fmt.Sprintf("%d attempt(s) failed [%s]", len(a.failures), strings.Join(a.failures, "; "))
+}
+
+// writeBadGateway sends the 502 for a request whose attempts have all failed, with the detail
+// of each failure in the body if the router has DebugErrors set.
+func (a *attempt) writeBadGateway(res http.ResponseWriter) {
+	if a.debug {
+		res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		res.WriteHeader(http.StatusBadGateway)
+		io.WriteString(res, a.detail()+"\n")
+		return
+	}
+	res.WriteHeader(http.StatusBadGateway)
+}
+
+func attemptFromContext(ctx context.Context) *attempt {
+	a, _ := ctx.Value(attemptKey{}).(*attempt)
+	return a
+}
+
+// replayable reports if the request body (if any) can be sent again.
+func replayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// proxy sends the request to one of the routers backends. If the backend fails and the routers
+// FailurePolicy allows it, the request is retried on another backend.
+func (l *LBLight) proxy(res http.ResponseWriter, req *http.Request, backendRouter *BackendRouter) {
+	policy := backendRouter.failurePolicy()
+	retries := policy.Retries
+	if !replayable(req) {
+		retries = 0
+	}
+
+	current := &attempt{debug: backendRouter.DebugErrors, servedBy: l.AddServedBy || backendRouter.AddServedBy}
+	if l.AddResponseTime || backendRouter.AddResponseTime {
+		current.received = receivedAt(req)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), attemptKey{}, current))
+
+	// backends are only released at the end, so retries go elsewhere. Deferred since ReverseProxy
+	// panics (http.ErrAbortHandler) to abort the client connection if the backend dies mid-body.
+	held := []*Backend{}
+	defer func() {
+		for _, be := range held {
+			backendRouter.ReleaseBackend(be)
+		}
+	}()
+
+	for i := 0; ; i++ {
+		// check if we have a backend for this router... if not, make one.
+		backend, err := backendRouter.GetBackendForRequest(req)
+		if err != nil {
+			if i == 0 {
+				if fallback, fallbackReq := backendRouter.fallbackFor(req, err); fallback != nil {
+					log.Warnf("No backend for URL %s from router %s, falling back to %s : %s", req.RequestURI, backendRouter.String(), fallback.String(), err.Error())
+					l.serveRouted(res, fallbackReq, fallback)
+					return
+				}
+				l.handleNoBackend(res, req, backendRouter, err)
+				return
+			}
+			log.Errorf("Unable to find backend to retry URL %s : %s, %s", req.RequestURI, err.Error(), current.detail())
+			current.writeBadGateway(res)
+			return
+		}
+
+		held = append(held, backend)
+		observeBackendSelected(req, backendRouter, backend)
+		if state := accessLogFromContext(req); state != nil {
+			state.backend = backend.ID
+		}
+
+		current.retryable = i < retries
+		current.err = nil
+		attemptCtx, cancel := context.WithCancel(req.Context())
+		done := l.inflight.add(InflightRequest{Method: req.Method, Path: req.URL.Path, Router: backendRouter.String(), Backend: backend.ID, Start: time.Now()}, func() {
+			atomic.StoreInt32(&current.cancelled, 1)
+			cancel()
+		})
+		func() {
+			defer done()
+			defer cancel()
+			backend.ReverseProxy.ServeHTTP(res, req.WithContext(attemptCtx))
+		}()
+		if current.err == nil {
+			return
+		}
+
+		log.Warnf("Retrying URL %s after error from backend %s : %s", req.RequestURI, backend.ID, current.err.Error())
+		select {
+		case <-time.After(policy.backoff(i)):
+		case <-req.Context().Done():
+			res.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+
+		if req.GetBody != nil {
+			req.Body, _ = req.GetBody()
+		}
+	}
+}
+
+// handleNoBackend responds when the router couldn't provide a backend.
+func (l *LBLight) handleNoBackend(res http.ResponseWriter, req *http.Request, backendRouter *BackendRouter, err error) {
+	if errors.Is(err, ErrUnknownForcedBackend) {
+		log.Warnf("Rejecting URL %s, %s %s isn't a backend of router %s", req.RequestURI, forceBackendHeader, req.Header.Get(forceBackendHeader), backendRouter.String())
+		http.Error(res, "unknown backend", http.StatusBadRequest)
+		return
+	}
+
+	if backendRouter.inMaintenance() {
+		backendRouter.MaintenanceResponse.write(res)
+		return
+	}
+	log.Errorf("Unable to find backend for URL %s : %s", req.RequestURI, err.Error())
+
+	// busy rather than broken, so tell the client when to come back.
+	if busyError(err) {
+		res.Header().Set("Retry-After", strconv.Itoa(backendRouter.retryAfterSeconds()))
+	}
+	res.WriteHeader(http.StatusServiceUnavailable)
+}
@@ -0,0 +1,141 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaturatedPoolRetryAfter(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		w.Write([]byte("ok"))
+	})
+
+	l := NewLBLight(0)
+	host, port := hostPort(t, backend)
+	ber := NewBackendRouter(host, port, nil, map[string]bool{"/": true}, 1)
+	ber.RetryAfterSeconds = 7
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := http.Get(lb.URL + "/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	resp, _ := get(t, lb.URL+"/")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 with the only backend busy, got %d", resp.StatusCode)
+	}
+	if ra := resp.Header.Get("Retry-After"); ra != "7" {
+		t.Errorf("expected Retry-After 7, got %q", ra)
+	}
+
+	close(release)
+	<-done
+	if resp, body := get(t, lb.URL+"/"); resp.StatusCode != http.StatusOK || body != "ok" {
+		t.Errorf("expected 200 once the backend is released, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestFailurePolicyBackoff(t *testing.T) {
+	fp := &FailurePolicy{RetryBackoff: 10 * time.Millisecond, MaxRetryBackoff: 50 * time.Millisecond}
+	for attempt, expected := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond} {
+		if backoff := fp.backoff(attempt); backoff != expected {
+			t.Errorf("attempt %d: expected backoff %s, got %s", attempt, expected, backoff)
+		}
+	}
+}
+
+func TestFailurePolicyRetriesAndBreaks(t *testing.T) {
+	dead := httptest.NewServer(textHandler("dead"))
+	dead.Close()
+	good := newBackendServer(t, textHandler("ok"))
+
+	for _, tt := range []struct {
+		retries int
+		status  int
+	}{
+		{0, http.StatusBadGateway},
+		{1, http.StatusOK},
+	} {
+		l := NewLBLight(0)
+		ber := NewBackendRouter("127.0.0.1", 9000, nil, map[string]bool{"/": true}, 2)
+		ber.AllowLazyCreation = false
+		ber.FailurePolicy = &FailurePolicy{
+			Retries:        tt.retries,
+			RetryBackoff:   200 * time.Millisecond,
+			CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: time.Minute},
+		}
+		deadBackend := ber.AddBackend(dead.URL, nil)
+		ber.AddBackend(good.URL, nil)
+		addRouter(t, l, ber)
+		lb := serveLB(t, l)
+
+		// the dead backend is first in the pool, so gets the first request.
+		start := time.Now()
+		resp, _ := get(t, lb.URL+"/")
+		elapsed := time.Since(start)
+		if resp.StatusCode != tt.status {
+			t.Errorf("Retries %d: expected %d, got %d", tt.retries, tt.status, resp.StatusCode)
+		}
+		if tt.retries > 0 && elapsed < 200*time.Millisecond {
+			t.Errorf("Retries %d: expected the retry to wait out the backoff, took %s", tt.retries, elapsed)
+		}
+		if state, _ := deadBackend.breaker.snapshot(); state != BreakerOpen {
+			t.Errorf("Retries %d: expected the dead backends breaker to be open, got %s", tt.retries, state)
+		}
+
+		// with the breaker open the dead backend is skipped, no retry needed.
+		start = time.Now()
+		if resp, body := get(t, lb.URL+"/"); resp.StatusCode != http.StatusOK || body != "ok" {
+			t.Errorf("Retries %d: expected the good backend with the breaker open, got %d %q", tt.retries, resp.StatusCode, body)
+		}
+		if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+			t.Errorf("Retries %d: expected no retry with the breaker open, took %s", tt.retries, elapsed)
+		}
+	}
+}
+
+func TestBadGatewayDetailListsEveryAttempt(t *testing.T) {
+	first := httptest.NewServer(textHandler("dead"))
+	first.Close()
+	second := httptest.NewServer(textHandler("dead"))
+	second.Close()
+
+	l := NewLBLight(0)
+	ber := NewBackendRouter("127.0.0.1", 9000, nil, map[string]bool{"/": true}, 2)
+	ber.AllowLazyCreation = false
+	ber.DebugErrors = true
+	ber.FailurePolicy = &FailurePolicy{Retries: 1}
+	firstBackend := ber.AddBackend(first.URL, nil)
+	secondBackend := ber.AddBackend(second.URL, nil)
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+
+	resp, body := get(t, lb.URL+"/")
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected 502 with both backends down, got %d", resp.StatusCode)
+	}
+	if !strings.HasPrefix(body, "2 attempt(s) failed") {
+		t.Errorf("expected the detail to count 2 attempts, got %q", body)
+	}
+	for _, be := range []*Backend{firstBackend, secondBackend} {
+		if !strings.Contains(body, be.ID+": ") || !strings.Contains(body, "connection refused") {
+			t.Errorf("expected the detail to list %s and its error, got %q", be.ID, body)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package pkg
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+func (l *LBLight) shutdownTimeout() time.Duration {
+	if l.ShutdownTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return l.ShutdownTimeout
+}
+
+// RunAll serves traffic for several LBLights at once (eg an internal and an external port, each with
+// their own routers) until ctx is cancelled, then shuts them all down gracefully.
+// If any of them fails to serve (eg port in use) the rest are shut down too and the error returned.
+func RunAll(ctx context.Context, lbs ...*LBLight) error {
+	// servers are set up before serving, so a shutdown can't miss one that hasn't started yet.
+	servers := make([]*http.Server, len(lbs))
+	for i, l := range lbs {
+		servers[i] = l.setupServer()
+	}
+
+	errs := make(chan error, len(lbs))
+	for i, server := range servers {
+		go func(l *LBLight, server *http.Server) {
+			errs <- l.serveTraffic(server)
+		}(lbs[i], server)
+	}
+
+	var err error
+	running := len(lbs)
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+		running--
+	}
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+
+	for _, l := range lbs {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), l.shutdownTimeout())
+		if shutdownErr := l.Shutdown(shutdownCtx); shutdownErr != nil {
+			log.Errorf("Unable to shutdown cleanly %s", shutdownErr.Error())
+		}
+		cancel()
+	}
+
+	for ; running > 0; running-- {
+		if serveErr := <-errs; err == nil && serveErr != http.ErrServerClosed {
+			err = serveErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// freePort returns a port that was free a moment ago.
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestRunAllServesAndShutsDown(t *testing.T) {
+	// the traffic listener serves TLS with localhost.crt and localhost.key from the working directory.
+	dir := t.TempDir()
+	certPEM, keyPEM := testCertificate(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "localhost.crt"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "localhost.key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	internal := NewLBLight(freePort(t))
+	addRouter(t, internal, routerFor(t, newBackendServer(t, textHandler("internal")), "/"))
+	external := NewLBLight(freePort(t))
+	addRouter(t, external, routerFor(t, newBackendServer(t, textHandler("external")), "/"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- RunAll(ctx, internal, external)
+	}()
+
+	client := &http.Client{Timeout: time.Second, Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	for _, tt := range []struct {
+		l    *LBLight
+		body string
+	}{
+		{internal, "internal"},
+		{external, "external"},
+	} {
+		uri := fmt.Sprintf("https://127.0.0.1:%d/", tt.l.port)
+		waitFor(t, tt.body+" serving", func() bool {
+			resp, err := client.Get(uri)
+			if err != nil {
+				return false
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			return resp.StatusCode == http.StatusOK && string(body) == tt.body
+		})
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected RunAll to return nil after a clean shutdown, got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("RunAll didn't return after cancel")
+	}
+
+	for _, l := range []*LBLight{internal, external} {
+		if conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", l.port)); err == nil {
+			conn.Close()
+			t.Errorf("port %d still accepting connections after shutdown", l.port)
+		}
+	}
+}
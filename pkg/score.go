@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"time"
+)
+
+// weight given to each new sample in the health score moving averages.
+const healthScoreAlpha = 0.2
+
+// floor for the selection weight, so a badly degraded backend still gets the odd request
+// and has a chance to recover its score.
+const minSelectionScore = 0.01
+
+// healthScoreLatency returns the latency at which a backends health score is halved.
+func (ber *BackendRouter) healthScoreLatency() time.Duration {
+	if ber.HealthScoreLatency <= 0 {
+		return 100 * time.Millisecond
+	}
+	return ber.HealthScoreLatency
+}
+
+// observeLatency folds a probe (or request) latency into the backends moving average.
+func (be *Backend) observeLatency(d time.Duration) {
+	be.mux.Lock()
+	defer be.mux.Unlock()
+
+	if !be.latencySeen {
+		be.latencyEMA = d.Seconds()
+		be.latencySeen = true
+		return
+	}
+	be.latencyEMA += healthScoreAlpha * (d.Seconds() - be.latencyEMA)
+}
+
+// observeResult folds a success/failure into the backends moving average error rate.
+func (be *Backend) observeResult(ok bool) {
+	be.mux.Lock()
+	defer be.mux.Unlock()
+
+	sample := 1.0
+	if ok {
+		sample = 0
+	}
+	be.errorRateEMA += healthScoreAlpha * (sample - be.errorRateEMA)
+}
+
+// HealthScore returns the backends health between 0 and 1 (perfect), combining the moving averages
+// of its latency and error rate. A backend with latency of the routers HealthScoreLatency and
+// no errors scores 0.5.
+func (be *Backend) HealthScore() float64 {
+	be.mux.RLock()
+	defer be.mux.RUnlock()
+
+	ref := be.scoreLatency.Seconds()
+	if ref <= 0 {
+		ref = (100 * time.Millisecond).Seconds()
+	}
+	return (1 - be.errorRateEMA) * ref / (ref + be.latencyEMA)
+}
+
+// selectByHealthScore picks an available backend at random, weighted by health score, so
+// a backend with half the score of the others gets roughly half the traffic.
+func (ber *BackendRouter) selectByHealthScore() *Backend {
+	return ber.selectWeightedRandom(func(be *Backend) float64 {
+		score := be.HealthScore()
+		if score < minSelectionScore {
+			score = minSelectionScore
+		}
+		return score
+	})
+}
@@ -0,0 +1,42 @@
+package pkg
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHealthScore(t *testing.T) {
+	be := newPool(1).backends[0]
+	be.observeLatency(100 * time.Millisecond)
+	if score := be.HealthScore(); math.Abs(score-0.5) > 0.001 {
+		t.Errorf("expected a score of 0.5 at the reference latency, got %f", score)
+	}
+
+	for i := 0; i < 50; i++ {
+		be.observeResult(false)
+	}
+	if score := be.HealthScore(); score > 0.01 {
+		t.Errorf("expected a failing backend to score close to 0, got %f", score)
+	}
+}
+
+func TestDegradedBackendGetsLessTraffic(t *testing.T) {
+	ber := newPool(2)
+	ber.Strategy = HealthScoreWeighted
+	healthy, degraded := ber.backends[0], ber.backends[1]
+	healthy.observeLatency(0)
+	// scores 0.5 against the healthy backends 1, so should get a third of the traffic.
+	degraded.observeLatency(100 * time.Millisecond)
+
+	const requests = 10000
+	degradedCount := 0
+	for i := 0; i < requests; i++ {
+		if ber.selectBackend(requestFrom("192.0.2.1:40000")) == degraded {
+			degradedCount++
+		}
+	}
+	if share := float64(degradedCount) / requests; share < 0.30 || share > 0.37 {
+		t.Errorf("expected the degraded backend to get about 33%% of traffic, got %.1f%%", share*100)
+	}
+}
@@ -0,0 +1,201 @@
+package pkg
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// SelectionStrategy determines how a BackendRouter picks a backend from its pool.
+type SelectionStrategy int
+
+const (
+	// FirstAvailable uses the first backend in the pool that's available. Default.
+	FirstAvailable SelectionStrategy = iota
+
+	// IPHash hashes the client IP to pick the backend, so a client keeps hitting the same
+	// backend (while it's available) without needing cookies. New backends only take over a
+	// share of the clients, the rest don't move as the pool grows.
+	IPHash
+
+	// ZoneAware prefers backends whose Metadata zone matches the zone in the requests ZoneHeader,
+	// falling back to any available backend.
+	ZoneAware
+
+	// HealthScoreWeighted picks backends at random weighted by their HealthScore, so degraded
+	// backends get proportionally less traffic.
+	HealthScoreWeighted
+
+	// WeightedRandom picks backends at random in proportion to their Weight. Each pick is independent,
+	// so several LBs in front of the same backends don't fall into step with each other.
+	WeightedRandom
+
+	// WeightedLeastConnections picks the backend with the fewest requests in flight for its Weight,
+	// so a backend with Weight 3 carries about three times the concurrent requests of one with 1.
+	// Backends for the same URL count as one server, their in flight requests add up and the servers
+	// weight is the highest Weight among them. Each backend only takes one request at a time, so give
+	// a server as many backends as its share of the concurrent requests (eg 3 for the Weight 3 one),
+	// with one backend per URL they can't carry more than one request each whatever the weights.
+	WeightedLeastConnections
+)
+
+// selectBackend picks an available backend for the request according to the routers strategy.
+// Returns nil if none are available. Caller must hold the routers lock.
+func (ber *BackendRouter) selectBackend(req *http.Request) *Backend {
+	if req != nil {
+		if ber.StickySession != nil {
+			if be := ber.selectSticky(req); be != nil {
+				return be
+			}
+		}
+
+		switch ber.Strategy {
+		case IPHash:
+			return ber.selectByHash(clientIP(req, ber.UseForwardedFor))
+		case HealthScoreWeighted:
+			return ber.selectByHealthScore()
+		case WeightedRandom:
+			return ber.selectWeightedRandom((*Backend).weight)
+		case WeightedLeastConnections:
+			return ber.selectWeightedLeastConnections()
+		case ZoneAware:
+			if be := ber.selectByMetadata(ber.zoneMetadataKey(), req.Header.Get(ber.ZoneHeader)); be != nil {
+				return be
+			}
+		}
+	}
+
+	return ber.selectFirstAvailable()
+}
+
+func (ber *BackendRouter) selectFirstAvailable() *Backend {
+	for _, be := range ber.backends {
+		if be.available() {
+			return be
+		}
+	}
+	return nil
+}
+
+func (ber *BackendRouter) zoneMetadataKey() string {
+	if ber.ZoneMetadataKey == "" {
+		return "zone"
+	}
+	return ber.ZoneMetadataKey
+}
+
+// selectByMetadata returns the first available backend with Metadata[key] == value.
+func (ber *BackendRouter) selectByMetadata(key string, value string) *Backend {
+	if value == "" {
+		return nil
+	}
+
+	for _, be := range ber.backends {
+		if be.Metadata[key] == value && be.available() {
+			return be
+		}
+	}
+	return nil
+}
+
+// selectByHash picks the available backend scoring highest for key (rendezvous hashing over the
+// backend IDs). Backends are created lazily, so the pool grows under clients: a new backend only
+// takes the keys it now scores highest for, everyone else stays put. If a keys backend isn't
+// available it consistently falls back to its next highest scoring one.
+func (ber *BackendRouter) selectByHash(key string) *Backend {
+	var best *Backend
+	var bestScore uint64
+	for _, be := range ber.backends {
+		if !be.available() {
+			continue
+		}
+		// the ID first, so the key mixes it in well.
+		h := fnv.New64a()
+		h.Write([]byte(be.ID))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if score := h.Sum64(); best == nil || score > bestScore {
+			best = be
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// selectWeightedRandom picks an available backend at random, in proportion to weight.
+func (ber *BackendRouter) selectWeightedRandom(weight func(*Backend) float64) *Backend {
+	candidates := []*Backend{}
+	weights := []float64{}
+	total := 0.0
+	for _, be := range ber.backends {
+		if !be.available() {
+			continue
+		}
+		w := weight(be)
+		candidates = append(candidates, be)
+		weights = append(weights, w)
+		total += w
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	pick := rand.Float64() * total
+	for i, w := range weights {
+		pick -= w
+		if pick < 0 {
+			return candidates[i]
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// selectWeightedLeastConnections picks the available backend whose server (URL) has the lowest in
+// flight requests to weight ratio. Ties go to the heavier server, then the first in the pool.
+func (ber *BackendRouter) selectWeightedLeastConnections() *Backend {
+	active := map[string]int{}
+	weights := map[string]float64{}
+	for _, be := range ber.backends {
+		server := be.url.String()
+		if be.InUse {
+			active[server]++
+		}
+		if be.weight() > weights[server] {
+			weights[server] = be.weight()
+		}
+	}
+
+	var best *Backend
+	bestLoad, bestWeight := 0.0, 0.0
+	for _, be := range ber.backends {
+		if !be.available() {
+			continue
+		}
+		server := be.url.String()
+		load := float64(active[server]) / weights[server]
+		if best == nil || load < bestLoad || (load == bestLoad && weights[server] > bestWeight) {
+			best = be
+			bestLoad = load
+			bestWeight = weights[server]
+		}
+	}
+	return best
+}
+
+// clientIP returns the IP of the client making the request. If useForwardedFor is set and
+// the request has an X-Forwarded-For header, the first (original client) entry is used.
+func clientIP(req *http.Request, useForwardedFor bool) string {
+	if useForwardedFor {
+		if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,196 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// requestFrom is a request from the client at remoteAddr.
+func requestFrom(remoteAddr string) *http.Request {
+	req, _ := http.NewRequest(http.MethodGet, "http://lb/", nil)
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+// newPool creates a router with n explicitly added backends, none of which are reachable.
+func newPool(n int) *BackendRouter {
+	ber := NewBackendRouter("127.0.0.1", 9000, nil, nil, n)
+	ber.AllowLazyCreation = false
+	for i := 0; i < n; i++ {
+		ber.AddBackend(fmt.Sprintf("http://10.0.0.%d:8080", i+1), nil)
+	}
+	return ber
+}
+
+func TestIPHashSameClientSameBackend(t *testing.T) {
+	ber := newPool(4)
+	ber.Strategy = IPHash
+
+	used := make(map[*Backend]bool)
+	for i := 0; i < 50; i++ {
+		ip := fmt.Sprintf("192.0.2.%d", i)
+		first := ber.selectBackend(requestFrom(ip + ":40000"))
+		for port := 40001; port < 40005; port++ {
+			if be := ber.selectBackend(requestFrom(fmt.Sprintf("%s:%d", ip, port))); be != first {
+				t.Fatalf("%s moved from %s to %s on a new connection", ip, first.ID, be.ID)
+			}
+		}
+		used[first] = true
+	}
+	if len(used) < 2 {
+		t.Errorf("50 clients all hashed onto %d backend(s)", len(used))
+	}
+}
+
+func TestIPHashStableAsPoolGrows(t *testing.T) {
+	ber := newPool(4)
+	ber.maxBackends = 5
+	ber.Strategy = IPHash
+
+	const clients = 1000
+	before := make(map[string]*Backend)
+	counts := make(map[*Backend]int)
+	for i := 0; i < clients; i++ {
+		ip := fmt.Sprintf("10.%d.%d.1", i/250, i%250)
+		before[ip] = ber.selectBackend(requestFrom(ip + ":40000"))
+		counts[before[ip]]++
+	}
+	for _, be := range ber.backends {
+		if share := float64(counts[be]) / clients; share < 0.15 || share > 0.35 {
+			t.Errorf("expected about a quarter of the clients on %s, got %.0f%%", be.ID, share*100)
+		}
+	}
+
+	// as when a backend is created lazily.
+	added := ber.AddBackend("http://10.0.0.5:8080", nil)
+	moved := 0
+	for ip, was := range before {
+		now := ber.selectBackend(requestFrom(ip + ":40001"))
+		if now == was {
+			continue
+		}
+		if now != added {
+			t.Fatalf("%s moved from %s to %s, expected only moves onto the new backend", ip, was.ID, now.ID)
+		}
+		moved++
+	}
+	// about a fifth should move to the new backend, modulo hashing would move most of them.
+	if share := float64(moved) / clients; share < 0.1 || share > 0.3 {
+		t.Errorf("expected about a fifth of the clients to move onto the new backend, got %.0f%%", share*100)
+	}
+}
+
+func TestIPHashForwardedFor(t *testing.T) {
+	ber := newPool(4)
+	ber.Strategy = IPHash
+	ber.UseForwardedFor = true
+
+	direct := ber.selectBackend(requestFrom("192.0.2.7:40000"))
+	for i := 0; i < 10; i++ {
+		req := requestFrom(fmt.Sprintf("198.51.100.%d:40000", i))
+		req.Header.Set("X-Forwarded-For", "192.0.2.7, 198.51.100.1")
+		if be := ber.selectBackend(req); be != direct {
+			t.Fatalf("forwarded client went to %s, expected %s", be.ID, direct.ID)
+		}
+	}
+}
+
+func TestZoneAwarePrefersClientZone(t *testing.T) {
+	ber := NewBackendRouter("127.0.0.1", 9000, nil, nil, 2)
+	ber.AllowLazyCreation = false
+	ber.Strategy = ZoneAware
+	ber.ZoneHeader = "X-Zone"
+	west := ber.AddBackend("http://10.0.0.1:8080", map[string]string{"zone": "us-west"})
+	east := ber.AddBackend("http://10.0.0.2:8080", map[string]string{"zone": "us-east"})
+
+	req := requestFrom("192.0.2.1:40000")
+	req.Header.Set("X-Zone", "us-east")
+	for i := 0; i < 10; i++ {
+		if be := ber.selectBackend(req); be != east {
+			t.Fatalf("expected the us-east backend, got %s", be.url.String())
+		}
+	}
+
+	// no backend in the zone free, fall back to the rest of the pool.
+	east.InUse = true
+	if be := ber.selectBackend(req); be != west {
+		t.Errorf("expected the fallback to us-west with us-east busy, got %v", be)
+	}
+}
+
+func TestWeightedRandomDistribution(t *testing.T) {
+	ber := newPool(3)
+	ber.Strategy = WeightedRandom
+	weights := []int{1, 2, 5}
+	for i, be := range ber.backends {
+		be.Weight = weights[i]
+	}
+
+	const requests = 16000
+	counts := make(map[*Backend]int)
+	for i := 0; i < requests; i++ {
+		counts[ber.selectBackend(requestFrom("192.0.2.1:40000"))]++
+	}
+	for i, be := range ber.backends {
+		expected := float64(weights[i]) / 8
+		if share := float64(counts[be]) / requests; share < expected-0.02 || share > expected+0.02 {
+			t.Errorf("backend with weight %d: expected %.1f%% of traffic, got %.1f%%", weights[i], expected*100, share*100)
+		}
+	}
+}
+
+func TestWeightedLeastConnectionsSplit(t *testing.T) {
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	var lightHits, heavyHits int32
+	holding := func(hits *int32) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(hits, 1)
+			<-release
+		}
+	}
+	light := newBackendServer(t, holding(&lightHits))
+	heavy := newBackendServer(t, holding(&heavyHits))
+
+	// each backend holds one request, so each server gets enough of them for its share.
+	l := NewLBLight(0)
+	ber := NewBackendRouter("127.0.0.1", 9000, nil, map[string]bool{"/": true}, 12)
+	ber.AllowLazyCreation = false
+	ber.Strategy = WeightedLeastConnections
+	for i := 0; i < 6; i++ {
+		ber.AddBackend(light.URL, nil).Weight = 1
+		be := ber.AddBackend(heavy.URL, nil)
+		// the weight is per server, setting it on one of its backends is enough.
+		if i == 0 {
+			be.Weight = 3
+		}
+	}
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+	t.Cleanup(func() { releaseOnce.Do(func() { close(release) }) })
+
+	const held = 8
+	done := make(chan struct{}, held)
+	for i := 0; i < held; i++ {
+		go func() {
+			if resp, err := http.Get(lb.URL + "/"); err == nil {
+				resp.Body.Close()
+			}
+			done <- struct{}{}
+		}()
+		waitFor(t, "the request to reach a backend", func() bool {
+			return atomic.LoadInt32(&lightHits)+atomic.LoadInt32(&heavyHits) == int32(i+1)
+		})
+	}
+
+	if lightN, heavyN := atomic.LoadInt32(&lightHits), atomic.LoadInt32(&heavyHits); lightN != 2 || heavyN != 6 {
+		t.Errorf("expected %d held requests split 1:3 (2 and 6), got %d and %d", held, lightN, heavyN)
+	}
+	releaseOnce.Do(func() { close(release) })
+	for i := 0; i < held; i++ {
+		<-done
+	}
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// BackendState is what's persisted of a backend by ExportState.
+type BackendState struct {
+	URL      string            `json:"url"`
+	Weight   int               `json:"weight,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// RouterState is the JSON written by ExportState.
+type RouterState struct {
+	Router   string         `json:"router"`
+	Backends []BackendState `json:"backends"`
+}
+
+// ExportState returns the routers backends (their URL, weight and metadata) as JSON, to be
+// persisted and restored with ImportState on a restart so the pool doesn't have to warm up again.
+func (ber *BackendRouter) ExportState() ([]byte, error) {
+	ber.mux.Lock()
+	state := RouterState{Router: ber.String(), Backends: []BackendState{}}
+	for _, be := range ber.backends {
+		state.Backends = append(state.Backends, BackendState{URL: be.url.String(), Weight: be.Weight, Metadata: be.Metadata})
+	}
+	ber.mux.Unlock()
+
+	return json.Marshal(state)
+}
+
+// ImportState adds the backends from JSON written by ExportState, meant for a freshly created router.
+// Nothing is added if the JSON can't be read or has an invalid URL. Health checked backends still have
+// to pass their probes before getting traffic. Routers with a Resolver can't import, the Resolver owns
+// their backend list.
+func (ber *BackendRouter) ImportState(data []byte) error {
+	if ber.Resolver != nil {
+		return fmt.Errorf("Unable to import state for router %s : backends come from its Resolver", ber.String())
+	}
+
+	var state RouterState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("Unable to read router state : %s", err.Error())
+	}
+	for _, bs := range state.Backends {
+		if bs.URL == "" {
+			return fmt.Errorf("Unable to read router state : backend without url")
+		}
+		// NewBackend exits on a bad URL, so catch them here.
+		u, err := url.Parse(bs.URL)
+		if err != nil {
+			return fmt.Errorf("Unable to read router state : %s", err.Error())
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("Unable to read router state : backend url %s isn't absolute", bs.URL)
+		}
+	}
+
+	ber.mux.Lock()
+	defer ber.mux.Unlock()
+	for _, bs := range state.Backends {
+		be := ber.newBackend(bs.URL)
+		be.Weight = bs.Weight
+		be.Metadata = bs.Metadata
+		ber.backends = append(ber.backends, be)
+	}
+	ber.notifyReleased()
+	return nil
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestExportImportStateRoundTrip(t *testing.T) {
+	a := newBackendServer(t, textHandler("a"))
+	b := newBackendServer(t, textHandler("b"))
+
+	original := NewBackendRouter("127.0.0.1", 9000, nil, map[string]bool{"/": true}, 2)
+	original.AddBackend(a.URL, map[string]string{"zone": "east"}).Weight = 1
+	original.AddBackend(b.URL, nil).Weight = 3
+	data, err := original.ExportState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original.Close()
+
+	l := NewLBLight(0)
+	restored := NewBackendRouter("127.0.0.1", 9000, nil, map[string]bool{"/": true}, 2)
+	restored.AllowLazyCreation = false
+	if err := restored.ImportState(data); err != nil {
+		t.Fatalf("ImportState: %s", err)
+	}
+	addRouter(t, l, restored)
+	lb := serveLB(t, l)
+
+	var got []BackendState
+	for _, be := range restored.backends {
+		got = append(got, BackendState{URL: be.url.String(), Weight: be.Weight, Metadata: be.Metadata})
+	}
+	expected := []BackendState{{URL: a.URL, Weight: 1, Metadata: map[string]string{"zone": "east"}}, {URL: b.URL, Weight: 3}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the restored backends %+v, got %+v", expected, got)
+	}
+	if resp, _ := get(t, lb.URL+"/"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the restored pool to serve traffic without creating backends, got %d", resp.StatusCode)
+	}
+}
+
+func TestImportStateRejectsBadState(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data string
+	}{
+		{"not json", "{"},
+		{"no url", `{"backends":[{"url":"http://10.0.0.1:80"},{"weight":2}]}`},
+		{"unparseable url", `{"backends":[{"url":"http://10.0.0.1:80"},{"url":"http://[::1"}]}`},
+		{"relative url", `{"backends":[{"url":"http://10.0.0.1:80"},{"url":"not a url"}]}`},
+	} {
+		ber := NewBackendRouter("127.0.0.1", 9000, nil, map[string]bool{"/": true}, 2)
+		if err := ber.ImportState([]byte(tt.data)); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+		if n := len(ber.backends); n != 0 {
+			t.Errorf("%s: expected nothing imported, got %d backends", tt.name, n)
+		}
+	}
+
+	resolver := &stubResolver{}
+	ber := NewBackendRouter("127.0.0.1", 9000, nil, map[string]bool{"/": true}, 2)
+	ber.Resolver = resolver
+	if err := ber.ImportState([]byte(`{"backends":[{"url":"http://10.0.0.1:80"}]}`)); err == nil {
+		t.Errorf("expected a router with a Resolver to refuse the import")
+	}
+	if n := len(ber.backends); n != 0 {
+		t.Errorf("expected nothing imported with a Resolver, got %d backends", n)
+	}
+}
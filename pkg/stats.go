@@ -0,0 +1,129 @@
+package pkg
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// responseSizeBuckets are the upper bounds (in bytes) of the response size histogram buckets.
+var responseSizeBuckets = []int64{1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20, 100 << 20}
+
+// HistogramStats is a snapshot of a histogram. Counts[i] is the number of observations
+// <= Buckets[i] (cumulative, like Prometheus), with the final entry of Counts being all observations.
+type HistogramStats struct {
+	Buckets []int64 `json:"buckets"`
+	Counts  []int64 `json:"counts"`
+	Sum     int64   `json:"sum"`
+	Count   int64   `json:"count"`
+}
+
+// sizeHistogram records sizes into responseSizeBuckets.
+type sizeHistogram struct {
+	mux    sync.Mutex
+	counts []int64
+	sum    int64
+	count  int64
+}
+
+func newSizeHistogram() *sizeHistogram {
+	h := sizeHistogram{}
+	h.counts = make([]int64, len(responseSizeBuckets)+1)
+	return &h
+}
+
+func (h *sizeHistogram) observe(size int64) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	bucket := len(responseSizeBuckets)
+	for i, upper := range responseSizeBuckets {
+		if size <= upper {
+			bucket = i
+			break
+		}
+	}
+	h.counts[bucket]++
+	h.sum += size
+	h.count++
+}
+
+func (h *sizeHistogram) snapshot() HistogramStats {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	hs := HistogramStats{Buckets: responseSizeBuckets, Sum: h.sum, Count: h.count}
+	cumulative := int64(0)
+	for _, count := range h.counts {
+		cumulative += count
+		hs.Counts = append(hs.Counts, cumulative)
+	}
+	return hs
+}
+
+// BackendStats is a point in time view of a single backend.
+type BackendStats struct {
+	ID           string  `json:"id"`
+	URL          string  `json:"url"`
+	Alive        bool    `json:"alive"`
+	InUse        bool    `json:"inUse"`
+	BreakerState string  `json:"breakerState,omitempty"`
+	BreakerTrips int64   `json:"breakerTrips"`
+	HealthScore  float64 `json:"healthScore"`
+
+	ResponseSizes HistogramStats `json:"responseSizes"`
+}
+
+// RouterStats is a point in time view of a BackendRouter and its backends.
+type RouterStats struct {
+	Router   string         `json:"router"`
+	Queued   int            `json:"queued"`
+	Paused   bool           `json:"paused"`
+	Backends []BackendStats `json:"backends"`
+}
+
+// Stats is a point in time view of the whole load balancer.
+type Stats struct {
+	Connections ConnectionStats `json:"connections"`
+
+	// BufferedBytes is the total size of the request bodies currently buffered.
+	BufferedBytes int64         `json:"bufferedBytes"`
+	Routers       []RouterStats `json:"routers"`
+}
+
+// Stats returns the current state of every registered router and its backends.
+func (l *LBLight) Stats() Stats {
+	l.mux.RLock()
+	routers := append([]*BackendRouter{}, l.routers...)
+	l.mux.RUnlock()
+
+	stats := Stats{}
+	stats.Connections = l.conns.snapshot()
+	stats.BufferedBytes = atomic.LoadInt64(&l.bufferedBytes)
+	for _, ber := range routers {
+		stats.Routers = append(stats.Routers, ber.stats())
+	}
+	return stats
+}
+
+func (ber *BackendRouter) stats() RouterStats {
+	ber.mux.Lock()
+	defer ber.mux.Unlock()
+
+	rs := RouterStats{Router: ber.String(), Queued: ber.queued, Paused: ber.paused}
+	for _, be := range ber.backends {
+		bs := BackendStats{}
+		bs.ID = be.ID
+		bs.URL = be.url.String()
+		bs.Alive = be.Alive
+		bs.InUse = be.InUse
+		if be.breaker != nil {
+			state, trips := be.breaker.snapshot()
+			bs.BreakerState = state.String()
+			bs.BreakerTrips = trips
+		}
+		bs.HealthScore = be.HealthScore()
+		bs.ResponseSizes = be.responseSizes.snapshot()
+		rs.Backends = append(rs.Backends, bs)
+	}
+	return rs
+}
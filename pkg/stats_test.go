@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseSizeHistogram(t *testing.T) {
+	backend := newBackendServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/big" {
+			w.Write([]byte(strings.Repeat("x", 5000)))
+			return
+		}
+		w.Write([]byte(strings.Repeat("x", 200)))
+	})
+
+	l := NewLBLight(0)
+	addRouter(t, l, routerFor(t, backend, "/"))
+	lb := serveLB(t, l)
+
+	for _, path := range []string{"/small", "/big"} {
+		if resp, body := get(t, lb.URL+path); resp.StatusCode != http.StatusOK || body == "" {
+			t.Fatalf("%s: expected 200 with a body, got %d", path, resp.StatusCode)
+		}
+	}
+
+	expected := HistogramStats{
+		Buckets: responseSizeBuckets,
+		Counts:  []int64{1, 2, 2, 2, 2, 2, 2},
+		Sum:     5200,
+		Count:   2,
+	}
+	// the size is recorded as the proxy closes the backend body, which can be just after the client is done.
+	var sizes HistogramStats
+	deadline := time.Now().Add(time.Second)
+	for {
+		sizes = l.Stats().Routers[0].Backends[0].ResponseSizes
+		if sizes.Count >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("expected response sizes %+v, got %+v", expected, sizes)
+	}
+}
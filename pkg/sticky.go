@@ -0,0 +1,108 @@
+package pkg
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StickySessionConfig pins a client to a backend with a cookie. The first response sets the cookie
+// to the backends ID and later requests carrying it go to the same backend while it's available,
+// falling back to the routers Strategy if it isn't.
+type StickySessionConfig struct {
+	// CookieName defaults to "lblight_backend".
+	CookieName string
+
+	// MaxAge of the cookie. 0 makes it a session cookie.
+	MaxAge time.Duration
+
+	Secure   bool
+	HttpOnly bool
+
+	// SameSite attribute of the cookie, eg http.SameSiteStrictMode. Zero leaves it off.
+	SameSite http.SameSite
+
+	// IdleTimeout ends a clients affinity once it hasn't made a request for this long, its next
+	// request is placed by the Strategy again. Lets load rebalance gradually (eg onto new backends)
+	// rather than clients staying pinned forever. The cookie carries when it was last refreshed.
+	// 0 (default) never expires affinity. Only sticky cookies expire, IPHash affinity isn't covered,
+	// a client hashes to the same backend for as long as the backends don't change.
+	IdleTimeout time.Duration
+}
+
+func (sc *StickySessionConfig) cookieName() string {
+	if sc.CookieName == "" {
+		return "lblight_backend"
+	}
+	return sc.CookieName
+}
+
+// cookie returns the cookie pinning the client to backend id, stamped with now if affinity expires.
+func (sc *StickySessionConfig) cookie(id string, now time.Time) *http.Cookie {
+	value := id
+	if sc.IdleTimeout > 0 {
+		value = id + "|" + strconv.FormatInt(now.Unix(), 10)
+	}
+	cookie := &http.Cookie{Name: sc.cookieName(), Value: value, Path: "/"}
+	cookie.MaxAge = int(sc.MaxAge / time.Second)
+	cookie.Secure = sc.Secure
+	cookie.HttpOnly = sc.HttpOnly
+	cookie.SameSite = sc.SameSite
+	return cookie
+}
+
+// stickyBackendID returns the backend ID from the requests sticky cookie, if any, and when the
+// cookie was stamped (zero if it isn't).
+func (sc *StickySessionConfig) stickyBackendID(req *http.Request) (string, time.Time) {
+	cookie, err := req.Cookie(sc.cookieName())
+	if err != nil {
+		return "", time.Time{}
+	}
+
+	parts := strings.SplitN(cookie.Value, "|", 2)
+	if len(parts) == 2 {
+		if stamp, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+			return parts[0], time.Unix(stamp, 0)
+		}
+	}
+	return parts[0], time.Time{}
+}
+
+// expired reports if affinity stamped at stamp has gone idle for longer than IdleTimeout.
+func (sc *StickySessionConfig) expired(stamp time.Time, now time.Time) bool {
+	return sc.IdleTimeout > 0 && !stamp.IsZero() && now.Sub(stamp) > sc.IdleTimeout
+}
+
+// selectSticky returns the backend named by the requests sticky cookie if it's available.
+// Caller must hold the routers lock.
+func (ber *BackendRouter) selectSticky(req *http.Request) *Backend {
+	id, stamp := ber.StickySession.stickyBackendID(req)
+	if id == "" || ber.StickySession.expired(stamp, time.Now()) {
+		return nil
+	}
+
+	for _, be := range ber.backends {
+		if be.ID == id && be.available() {
+			return be
+		}
+	}
+	return nil
+}
+
+// setStickyCookie adds the sticky cookie to the response, unless the client already has the right one.
+// With IdleTimeout the cookie is refreshed once it's a quarter of the way to expiring, to keep
+// active clients pinned without a Set-Cookie on every response.
+func (ber *BackendRouter) setStickyCookie(be *Backend, resp *http.Response) {
+	sc := ber.StickySession
+	if sc == nil {
+		return
+	}
+
+	now := time.Now()
+	id, stamp := sc.stickyBackendID(resp.Request)
+	if id == be.ID && (sc.IdleTimeout <= 0 || (!stamp.IsZero() && now.Sub(stamp) < sc.IdleTimeout/4)) {
+		return
+	}
+	resp.Header.Add("Set-Cookie", sc.cookie(be.ID, now).String())
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStickyCookieAttributes(t *testing.T) {
+	a := newBackendServer(t, textHandler("a"))
+	b := newBackendServer(t, textHandler("b"))
+
+	l := NewLBLight(0)
+	ber := NewBackendRouter("127.0.0.1", 9000, nil, map[string]bool{"/": true}, 2)
+	ber.AllowLazyCreation = false
+	ber.StickySession = &StickySessionConfig{
+		CookieName: "pin",
+		MaxAge:     time.Hour,
+		Secure:     true,
+		HttpOnly:   true,
+		SameSite:   http.SameSiteStrictMode,
+	}
+	ber.AddBackend(a.URL, nil)
+	ber.AddBackend(b.URL, nil)
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+	bID := backendIDs(ber)[b.URL]
+
+	resp, body := get(t, lb.URL+"/")
+	if body != "a" {
+		t.Fatalf("expected the first backend without a cookie, got %q", body)
+	}
+	expected := "pin=" + backendIDs(ber)[a.URL] + "; Path=/; Max-Age=3600; HttpOnly; Secure; SameSite=Strict"
+	if cookie := resp.Header.Get("Set-Cookie"); cookie != expected {
+		t.Errorf("expected Set-Cookie %q, got %q", expected, cookie)
+	}
+
+	// pinned to b, which FirstAvailable wouldn't pick.
+	req, _ := http.NewRequest(http.MethodGet, lb.URL+"/", nil)
+	req.AddCookie(&http.Cookie{Name: "pin", Value: bID})
+	resp, body = doRequest(t, req)
+	if body != "b" {
+		t.Errorf("expected the pinned backend b, got %q", body)
+	}
+	if cookie := resp.Header.Get("Set-Cookie"); cookie != "" {
+		t.Errorf("expected no Set-Cookie for a client already pinned, got %q", cookie)
+	}
+}
+
+func TestStickyIdleTimeoutRebalances(t *testing.T) {
+	a := newBackendServer(t, textHandler("a"))
+	b := newBackendServer(t, textHandler("b"))
+
+	l := NewLBLight(0)
+	ber := NewBackendRouter("127.0.0.1", 9000, nil, map[string]bool{"/": true}, 2)
+	ber.AllowLazyCreation = false
+	ber.StickySession = &StickySessionConfig{CookieName: "pin", IdleTimeout: time.Minute}
+	ber.AddBackend(a.URL, nil)
+	ber.AddBackend(b.URL, nil)
+	addRouter(t, l, ber)
+	lb := serveLB(t, l)
+	ids := backendIDs(ber)
+
+	pinnedAt := func(stamp time.Time) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, lb.URL+"/", nil)
+		req.AddCookie(&http.Cookie{Name: "pin", Value: ids[b.URL] + "|" + strconv.FormatInt(stamp.Unix(), 10)})
+		return doRequest(t, req)
+	}
+
+	if resp, body := pinnedAt(time.Now()); body != "b" || resp.Header.Get("Set-Cookie") != "" {
+		t.Errorf("expected a recently active client to stay on b without a new cookie, got %q %q", body, resp.Header.Get("Set-Cookie"))
+	}
+
+	// idle for longer than IdleTimeout, placed by the Strategy again (FirstAvailable picks a) and re-pinned.
+	resp, body := pinnedAt(time.Now().Add(-2 * time.Minute))
+	if body != "a" {
+		t.Errorf("expected an expired affinity to be rebalanced onto a, got %q", body)
+	}
+	if cookie := resp.Header.Get("Set-Cookie"); !strings.HasPrefix(cookie, "pin="+ids[a.URL]+"|") {
+		t.Errorf("expected a fresh cookie pinning the client to a, got %q", cookie)
+	}
+}
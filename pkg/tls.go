@@ -0,0 +1,33 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// tlsConfig is the tls.Config for the traffic listener built from the TLS options, nil if none are
+// set (the net/http defaults). The certificate is added by ServeTLS.
+func (l *LBLight) tlsConfig() *tls.Config {
+	if l.TLSMinVersion == 0 && len(l.TLSCipherSuites) == 0 && l.TLSNextProtos == nil {
+		return nil
+	}
+	return &tls.Config{
+		MinVersion:   l.TLSMinVersion,
+		CipherSuites: l.TLSCipherSuites,
+		NextProtos:   l.TLSNextProtos,
+	}
+}
+
+// applyNextProtos turns off HTTP/2 if TLSNextProtos is set without "h2". net/http would otherwise
+// add it back when it sets up HTTP/2 on the server.
+func (l *LBLight) applyNextProtos(server *http.Server) {
+	if l.TLSNextProtos == nil {
+		return
+	}
+	for _, proto := range l.TLSNextProtos {
+		if proto == "h2" {
+			return
+		}
+	}
+	server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+}
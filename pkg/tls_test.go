@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+// serveLBTLS serves traffic through the LBs own http.Server over TLS, with a test certificate,
+// returning the address. Shut down when the test ends.
+func serveLBTLS(t *testing.T, l *LBLight) string {
+	t.Helper()
+	dir := t.TempDir()
+	certPEM, keyPEM := testCertificate(t)
+	certFile, keyFile := filepath.Join(dir, "localhost.crt"), filepath.Join(dir, "localhost.key")
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := l.setupServer()
+	go server.ServeTLS(l.wrapListener(ln), certFile, keyFile)
+	t.Cleanup(func() {
+		l.Shutdown(context.Background())
+		server.Close()
+	})
+	return ln.Addr().String()
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	backend := newBackendServer(t, textHandler("ok"))
+
+	l := NewLBLight(0)
+	l.TLSMinVersion = tls.VersionTLS13
+	addRouter(t, l, routerFor(t, backend, "/"))
+	addr := serveLBTLS(t, l)
+
+	for _, tt := range []struct {
+		maxVersion uint16
+		ok         bool
+	}{
+		{tls.VersionTLS12, false},
+		{tls.VersionTLS13, true},
+	} {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, MaxVersion: tt.maxVersion})
+		if conn != nil {
+			conn.Close()
+		}
+		if tt.ok && err != nil {
+			t.Errorf("expected a TLS 1.3 client to connect, got %s", err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("expected the handshake with a TLS 1.2 client to fail")
+		}
+	}
+}
+
+func TestTLSNextProtosWithoutH2(t *testing.T) {
+	backend := newBackendServer(t, textHandler("ok"))
+
+	for _, tt := range []struct {
+		nextProtos []string
+		proto      string
+	}{
+		// net/http negotiates HTTP/2 by default.
+		{nil, "HTTP/2.0"},
+		{[]string{"http/1.1"}, "HTTP/1.1"},
+	} {
+		l := NewLBLight(0)
+		l.TLSNextProtos = tt.nextProtos
+		addRouter(t, l, routerFor(t, backend, "/"))
+		addr := serveLBTLS(t, l)
+
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2", "http/1.1"}},
+			ForceAttemptHTTP2: true,
+		}}
+		resp, err := client.Get("https://" + addr + "/")
+		if err != nil {
+			t.Fatalf("TLSNextProtos %v: %s", tt.nextProtos, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.Proto != tt.proto || string(body) != "ok" {
+			t.Errorf("TLSNextProtos %v: expected %s ok for an h2 preferring client, got %s %q", tt.nextProtos, tt.proto, resp.Proto, body)
+		}
+		client.CloseIdleConnections()
+	}
+}